@@ -2,8 +2,10 @@ package main
 
 import (
 	"context"
+	"errors"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/alecthomas/kong"
 	"github.com/aws/aws-sdk-go-v2/config"
@@ -19,29 +21,82 @@ import (
 var version string
 
 var cli struct {
-	Version       kong.VersionFlag
-	EcspressoCmd  string `env:"ECSPRESSO_CMD" required:"" default:"ecspresso" help:"ecspresso command path."`
-	EcspressoOpts string `env:"ECSPRESSO_OPTS" short:"X" help:"Options passed to ecspresso."`
-	DryRun        bool   `default:"false" help:"Run ecspresso with dry-run."`
-	AwsProfile    string `env:"AWS_PROFILE" short:"P" help:"AWS profile name"`
+	Version          kong.VersionFlag
+	Launcher         string        `env:"DMTS_LAUNCHER" enum:"ecspresso,native" default:"ecspresso" help:"Task launcher (ecspresso, native)."`
+	EcspressoCmd     string        `env:"ECSPRESSO_CMD" required:"" default:"ecspresso" help:"ecspresso command path."`
+	EcspressoOpts    string        `env:"ECSPRESSO_OPTS" short:"X" help:"Options passed to ecspresso."`
+	DryRun           bool          `default:"false" help:"Run ecspresso with dry-run."`
+	AwsProfile       string        `env:"AWS_PROFILE" short:"P" help:"AWS profile name"`
+	ReadyInterval    time.Duration `env:"DMTS_READY_INTERVAL" default:"1s" help:"Initial polling interval while waiting for ECS Exec to be ready."`
+	ReadyMaxInterval time.Duration `env:"DMTS_READY_MAX_INTERVAL" default:"10s" help:"Maximum polling interval while waiting for ECS Exec to be ready."`
+	SsmPlugin        bool          `env:"DMTS_SSM_PLUGIN" default:"false" help:"Use session-manager-plugin (aws ssm start-session) instead of the built-in Session Manager client for port forwarding."`
 	definition.DefinitionOpts
 	Run                subcmd.RunCmd                `cmd:"" help:"Run ECS task."`
 	Exec               subcmd.ExecCmd               `cmd:"" help:"Run ECS task and execute a command on a container."`
 	PortForward        subcmd.PortForwardCmd        `cmd:"" help:"Forward a local port to a container."`
+	Attach             subcmd.AttachCmd             `cmd:"" help:"Execute a command on a running task launched by demitas."`
+	Cp                 subcmd.CpCmd                 `cmd:"" help:"Copy a file between local and a running task launched by demitas."`
+	Ps                 subcmd.PsCmd                 `cmd:"" help:"List running tasks launched by demitas."`
+	Stop               subcmd.StopCmd               `cmd:"" help:"Stop tasks launched by demitas."`
+	Gc                 subcmd.GcCmd                 `cmd:"" help:"Stop tasks left behind by killed dmts processes on this host (tasks recorded on other hosts are treated as alive)."`
 	Profiles           subcmd.ProfilesCmd           `cmd:"" help:"List profiles."`
+	Render             subcmd.RenderCmd             `cmd:"" help:"Print merged definitions without running ECS task."`
+	Explain            subcmd.ExplainCmd            `cmd:"" help:"Explain which layer set a field in merged definitions."`
 	InstallCompletions kongplete.InstallCompletions `cmd:"" help:"Install shell completions"`
 }
 
+// NOTE: Flags are not parsed yet on completion, so parse only the conf dir to apply defaults and environment variables
+func predictProfiles(args complete.Args) []string {
+	var opts struct {
+		definition.DefinitionOpts
+	}
+
+	parser, err := kong.New(&opts)
+
+	if err != nil {
+		return nil
+	}
+
+	confDirArgs := []string{}
+
+	for i, arg := range args.All {
+		if (arg == "-d" || arg == "--conf-dir") && i+1 < len(args.All) {
+			confDirArgs = []string{"--conf-dir", args.All[i+1]}
+		} else if strings.HasPrefix(arg, "--conf-dir=") {
+			confDirArgs = []string{arg}
+		}
+	}
+
+	_, err = parser.Parse(confDirArgs)
+
+	if err != nil {
+		return nil
+	}
+
+	profiles, err := opts.Profiles()
+
+	if err != nil {
+		return nil
+	}
+
+	return profiles
+}
+
 func main() {
 	parser := kong.Must(&cli, kong.Vars{"version": version})
 
 	kongplete.Complete(parser,
 		kongplete.WithPredictor("file", complete.PredictFiles("*")),
+		kongplete.WithPredictor("profile", complete.PredictFunc(predictProfiles)),
 	)
 
 	ctx, err := parser.Parse(os.Args[1:])
 	parser.FatalIfErrorf(err)
 
+	if cli.ReadyInterval <= 0 || cli.ReadyMaxInterval < cli.ReadyInterval {
+		parser.Fatalf("--ready-interval must be positive and not greater than --ready-max-interval")
+	}
+
 	os.Setenv("AWS_PROFILE", cli.AwsProfile)
 
 	if strings.TrimSpace(os.Getenv("AWS_PROFILE")) == "" {
@@ -54,18 +109,28 @@ func main() {
 		panic(err)
 	}
 
-	ecsp, err := ecspresso.NewEcspresso(cli.EcspressoCmd, cli.EcspressoOpts)
+	driver := ecscli.NewDriver(cfg)
+	driver.UseSessionManagerPlugin = cli.SsmPlugin
+	driver.ReadyBackoff = ecscli.Backoff{Min: cli.ReadyInterval, Max: cli.ReadyMaxInterval}
+	var runner demitas2.TaskRunner = driver
 
-	if err != nil {
-		ctx.FatalIfErrorf(err)
+	if cli.Launcher == "ecspresso" {
+		runner = ecspresso.NewEcspresso(cli.EcspressoCmd, cli.EcspressoOpts)
 	}
 
 	err = ctx.Run(&demitas2.Context{
-		Ecspresso:      ecsp,
+		Version:        version,
+		Runner:         runner,
 		DryRun:         cli.DryRun,
 		DefinitionOpts: &cli.DefinitionOpts,
-		Ecs:            ecscli.NewDriver(cfg),
+		Ecs:            driver,
 	})
 
+	var exitErr *demitas2.ExitError
+
+	if errors.As(err, &exitErr) {
+		os.Exit(exitErr.Code)
+	}
+
 	ctx.FatalIfErrorf(err)
 }
@@ -1,14 +1,78 @@
 package demitas2
 
 import (
+	"context"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
 	"github.com/kanmu/demitas2/definition"
-	"github.com/kanmu/demitas2/ecscli"
-	"github.com/kanmu/demitas2/ecspresso"
+	"github.com/kanmu/demitas2/utils"
 )
 
+// TaskRunner launches an ECS task from the merged definitions and waits for it.
+type TaskRunner interface {
+	RunUntilRunning(def *definition.Definition, dryRun bool) (taskId string, interrupted bool, err error)
+	RunUntilStopped(def *definition.Definition, dryRun bool) (taskId string, interrupted bool, err error)
+}
+
+// Driver operates an ECS task launched by TaskRunner.
+type Driver interface {
+	StopTask(cluster string, taskId string) error
+	DescribeTask(cluster string, taskId string) (*types.Task, error)
+	TagTask(cluster string, taskId string, tags map[string]string) error
+	ListTasks(cluster string) ([]types.Task, error)
+	DescribeTaskDefinition(taskDefArn string) (*types.TaskDefinition, error)
+	GetContainerId(cluster string, taskId string, container string) (string, error)
+	WaitForExecuteCommandAgent(ctx context.Context, cluster string, taskId string, container string, timeout time.Duration) error
+	ExecuteInteractiveCommand(cluster string, taskId string, container string, command string) error
+	ExecuteNonInteractiveCommand(cluster string, taskId string, container string, command string, in io.Reader, out io.Writer) (int, error)
+	TailLogs(ctx context.Context, region string, group string, streamPrefix string, out io.Writer) error
+	// NOTE: ready is closed when all local ports accept connections (optional)
+	StartPortForwardingSessions(ctx context.Context, cluster string, taskId string, containerId string, forwards []PortForward, ready chan<- struct{}) error
+}
+
 type Context struct {
-	Ecspresso      *ecspresso.Ecspresso
+	Version        string
+	Runner         TaskRunner
 	DryRun         bool
 	DefinitionOpts *definition.DefinitionOpts
-	Ecs            *ecscli.Driver
+	Ecs            Driver
+	// Interrupt receives SIGINT (default: SIGINT of the process)
+	Interrupt <-chan os.Signal
+	// Exit exits the process (default: os.Exit)
+	Exit func(code int)
+}
+
+// NotifyInterrupt returns a channel that receives SIGINT and a function to stop receiving it.
+func (ctx *Context) NotifyInterrupt() (<-chan os.Signal, func()) {
+	if ctx.Interrupt != nil {
+		return ctx.Interrupt, func() {}
+	}
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt)
+	signal.Ignore(syscall.SIGURG)
+	signal.Ignore(syscall.SIGWINCH)
+
+	return sig, func() { signal.Stop(sig) }
+}
+
+func (ctx *Context) exit(code int) {
+	if ctx.Exit != nil {
+		ctx.Exit(code)
+		return
+	}
+
+	os.Exit(code)
+}
+
+// TrapInt runs proc and then teardown, and runs teardown and exits with 130 on SIGINT.
+func (ctx *Context) TrapInt(proc func() error, teardown func()) error {
+	sig, stop := ctx.NotifyInterrupt()
+	defer stop()
+	return utils.TrapInt(sig, ctx.exit, proc, teardown)
 }
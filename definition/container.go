@@ -3,8 +3,8 @@ package definition
 import (
 	"encoding/json"
 	"fmt"
-	"os"
 	"regexp"
+	"strconv"
 	"strings"
 
 	jsonpatch "github.com/evanphx/json-patch"
@@ -15,41 +15,103 @@ import (
 
 type ContainerDefinition struct {
 	Content []byte
+	trace   *Trace
+	// logConfiguration removed by demitas
+	origLogConfiguration []byte
 }
 
-func newContainerDefinition(path string, taskDefPath string) (*ContainerDefinition, error) {
-	var content []byte
-	var err error
-
-	if _, err = os.Stat(path); err != nil {
-		content, err = readContainerDefFromTaskDef(taskDefPath)
+// NOTE: Read the container from the task definitions if there is no container definition file
+func newContainerDefinition(paths []string, taskDefPaths []string, name string, trace *Trace) (*ContainerDefinition, error) {
+	if len(paths) == 0 {
+		content, index, err := readContainerDefFromTaskDef(taskDefPaths, name)
 
 		if err != nil {
-			return nil, fmt.Errorf("failed to load ECS task definition (instead of ECS container definition): %w: %s", err, taskDefPath)
+			return nil, fmt.Errorf("failed to load ECS task definition (instead of ECS container definition): %w: %s", err, strings.Join(taskDefPaths, ", "))
 		}
-	} else {
-		content, err = utils.ReadJSONorJsonnet(path)
+
+		containerDef := &ContainerDefinition{
+			Content: content,
+			trace:   trace,
+		}
+
+		source := fmt.Sprintf("%s#containerDefinitions.%d", taskDefPaths[len(taskDefPaths)-1], index)
+		trace.record(targetContainerDefinition, Layer{Name: "base", Source: source}, content)
+
+		return containerDef, nil
+	}
+
+	content, err := mergeFiles(paths, readContainerDefinition, func(path string, content []byte) {
+		trace.record(targetContainerDefinition, Layer{Name: "base", Source: path}, content)
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	// NOTE: The container definition file replaces the main container, so the names must match
+	if name != "" {
+		var p fastjson.Parser
+		v, err := p.ParseBytes(content)
 
 		if err != nil {
-			return nil, fmt.Errorf("failed to load ECS container definition: %w: %s", err, path)
+			return nil, fmt.Errorf("failed to parse ECS container definition: %w: %s", err, paths[len(paths)-1])
+		}
+
+		if fileName := string(v.GetStringBytes("name")); fileName == "" {
+			content, err = jsonpatch.MergePatch(content, []byte(`{"name":`+strconv.Quote(name)+`}`))
+
+			if err != nil {
+				return nil, fmt.Errorf("failed to update 'name' in ECS container definition: %w", err)
+			}
+
+			trace.record(targetContainerDefinition, layerDemitasMainContainer, content)
+		} else if fileName != name {
+			return nil, fmt.Errorf("main container '%s' does not match the name '%s' in ECS container definition: %s", name, fileName, paths[len(paths)-1])
 		}
 	}
 
 	containerDef := &ContainerDefinition{
 		Content: content,
+		trace:   trace,
 	}
 
 	return containerDef, nil
 }
 
-func (containerDef *ContainerDefinition) patch(overrides string, command string, image string, initProcessEnabled bool) error {
+func readContainerDefinition(path string) ([]byte, error) {
+	content, err := utils.ReadJSONorJsonnet(path)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to load ECS container definition: %w: %s", err, path)
+	}
+
+	return content, nil
+}
+
+func (containerDef *ContainerDefinition) patch(overrides string, layer Layer, command string, image string, initProcessEnabled bool) error {
 	overrides = strings.TrimSpace(overrides)
+
+	{
+		var p fastjson.Parser
+		v, err := p.ParseBytes(containerDef.Content)
+
+		if err != nil {
+			return fmt.Errorf("failed to parse ECS container definition: %w", err)
+		}
+
+		if logConf := v.Get("logConfiguration"); logConf != nil && logConf.Type() == fastjson.TypeObject {
+			containerDef.origLogConfiguration = logConf.MarshalTo(nil)
+		}
+	}
+
 	patchedContent0, err := jsonpatch.MergePatch(containerDef.Content, []byte(`{"logConfiguration":null}`))
 
 	if err != nil {
 		return fmt.Errorf("failed to patch ECS container definition: %w", err)
 	}
 
+	containerDef.trace.record(targetContainerDefinition, layerDemitasLogConfiguration, patchedContent0)
+
 	if command != "" {
 		args, err := shellwords.Parse(command)
 
@@ -68,6 +130,8 @@ func (containerDef *ContainerDefinition) patch(overrides string, command string,
 		if err != nil {
 			return fmt.Errorf("failed to update 'command' in ECS container definition: %w", err)
 		}
+
+		containerDef.trace.record(targetContainerDefinition, Layer{Name: "cli", Source: "--command"}, patchedContent0)
 	}
 
 	if image != "" {
@@ -88,6 +152,8 @@ func (containerDef *ContainerDefinition) patch(overrides string, command string,
 		if err != nil {
 			return fmt.Errorf("failed to update 'image' in ECS container definition: %w", err)
 		}
+
+		containerDef.trace.record(targetContainerDefinition, Layer{Name: "cli", Source: "--image"}, patchedContent0)
 	}
 
 	if initProcessEnabled {
@@ -96,6 +162,8 @@ func (containerDef *ContainerDefinition) patch(overrides string, command string,
 		if err != nil {
 			return fmt.Errorf("failed to update 'initProcessEnabled' in ECS container definition: %w", err)
 		}
+
+		containerDef.trace.record(targetContainerDefinition, layerDemitasInitProcess, patchedContent0)
 	}
 
 	{
@@ -126,6 +194,8 @@ func (containerDef *ContainerDefinition) patch(overrides string, command string,
 		if err != nil {
 			panic(err)
 		}
+
+		containerDef.trace.record(targetContainerDefinition, layerDemitasEnv, patchedContent0)
 	}
 
 	var patchedContent []byte
@@ -136,6 +206,8 @@ func (containerDef *ContainerDefinition) patch(overrides string, command string,
 		if err != nil {
 			return fmt.Errorf("failed to patch ECS container definition: %w", err)
 		}
+
+		containerDef.trace.record(targetContainerDefinition, layer, patchedContent)
 	} else {
 		patchedContent = patchedContent0
 	}
@@ -145,28 +217,65 @@ func (containerDef *ContainerDefinition) patch(overrides string, command string,
 	return nil
 }
 
-func readContainerDefFromTaskDef(path string) ([]byte, error) {
-	content, err := utils.ReadJSONorJsonnet(path)
+// NOTE: Unlike patch, merge applies only the overrides so that it can be called for each overrides file
+func (containerDef *ContainerDefinition) merge(overrides string, layer Layer) error {
+	patchedContent, err := jsonpatch.MergePatch(containerDef.Content, []byte(overrides))
 
 	if err != nil {
-		return nil, err
+		return fmt.Errorf("failed to patch ECS container definition: %w", err)
+	}
+
+	containerDef.trace.record(targetContainerDefinition, layer, patchedContent)
+	containerDef.Content = patchedContent
+
+	return nil
+}
+
+func (containerDef *ContainerDefinition) name() string {
+	var p fastjson.Parser
+	v, err := p.ParseBytes(containerDef.Content)
+
+	if err != nil {
+		return ""
+	}
+
+	return string(v.GetStringBytes("name"))
+}
+
+// NOTE: Read the first container if the name is empty
+func readContainerDefFromTaskDef(paths []string, name string) ([]byte, int, error) {
+	if len(paths) == 0 {
+		return nil, 0, fmt.Errorf("ECS task definition not found")
+	}
+
+	content, err := mergeFiles(paths, utils.ReadJSONorJsonnet, func(string, []byte) {})
+
+	if err != nil {
+		return nil, 0, err
 	}
 
 	var p fastjson.Parser
 	v, err := p.ParseBytes(content)
 
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 
-	containerDef := v.GetObject("containerDefinitions", "0")
+	if name == "" {
+		containerDef := v.GetObject("containerDefinitions", "0")
 
-	// NOTE: Ignore dependsOn
-	containerDef.Del("dependsOn")
+		if containerDef == nil {
+			return nil, 0, fmt.Errorf("'containerDefinitions.0' is not found in ECS task definition")
+		}
+
+		return containerDef.MarshalTo(nil), 0, nil
+	}
 
-	if containerDef == nil {
-		return nil, fmt.Errorf("'containerDefinitions.0' is not found in ECS task definition: %s", path)
+	for i, containerDef := range v.GetArray("containerDefinitions") {
+		if string(containerDef.GetStringBytes("name")) == name {
+			return containerDef.MarshalTo(nil), i, nil
+		}
 	}
 
-	return containerDef.MarshalTo(nil), nil
+	return nil, 0, fmt.Errorf("container '%s' is not found in ECS task definition", name)
 }
@@ -3,6 +3,7 @@ package definition
 import (
 	"encoding/json"
 	"fmt"
+	"io/fs"
 	"os"
 	"path/filepath"
 	"strings"
@@ -20,6 +21,8 @@ type DefinitionOpts struct {
 	TaskOverrides      string   `short:"t" help:"JSON/YAML string that overrides ECS task definition."`
 	ContainerOverrides string   `short:"c" help:"JSON/YAML string that overrides ECS container definition."`
 	Cluster            string   `env:"DMTS_CLUSTER" help:"ECS cluster name."`
+	MainContainer      string   `env:"DMTS_MAIN_CONTAINER" help:"Main container name in ECS task definition (default: first container)."`
+	Sidecars           []string `env:"DMTS_SIDECARS" help:"Sidecar container names kept in ECS task definition."`
 	OverridesFile      string   `env:"DMTS_OVERRIDES_FILE" default:".demitas.jsonnet" help:"demitas overrides config file name."`
 }
 
@@ -28,6 +31,10 @@ type Definition struct {
 	Service         *ServiceDefinition
 	Task            *TaskDefinition
 	Cluster         string
+	MainContainer   string
+	Overrides       *Overrides
+	// logConfiguration of the main container before demitas removes it
+	origLogConfiguration []byte
 }
 
 func (opts *DefinitionOpts) ExpandConfDir() string {
@@ -41,19 +48,102 @@ func (opts *DefinitionOpts) ExpandConfDir() string {
 }
 
 func (opts *DefinitionOpts) Load(profile string, command string, image string, cpu uint64, memory uint64, initProcessEnabled bool) (*Definition, error) {
+	return opts.load(profile, command, image, cpu, memory, initProcessEnabled, nil)
+}
+
+// LoadWithTrace loads definitions and records every merge step.
+func (opts *DefinitionOpts) LoadWithTrace(profile string, command string, image string, cpu uint64, memory uint64, initProcessEnabled bool) (*Definition, *Trace, error) {
+	trace := &Trace{}
+	def, err := opts.load(profile, command, image, cpu, memory, initProcessEnabled, trace)
+	return def, trace, err
+}
+
+// PortForwards loads only the overrides files of the profile (and the profiles it extends)
+// and returns the named targets of port forwarding.
+func (opts *DefinitionOpts) PortForwards(profile string) ([]*PortForwardTarget, error) {
+	_, overrides, err := loadProfile(opts.profileDir(profile), opts)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return overrides.PortForwards()
+}
+
+func (opts *DefinitionOpts) profileDir(profile string) string {
 	confDir := opts.ExpandConfDir()
 
 	if profile != "" {
 		confDir = filepath.Join(confDir, profile)
 	}
 
-	overrides, err := loadOverridesFile(confDir, opts)
+	return confDir
+}
+
+// Profiles returns the names of the profiles under the conf dir including nested ones (e.g. "prod/api").
+// NOTE: A profile dir has an ecspresso config or extends another profile dir
+func (opts *DefinitionOpts) Profiles() ([]string, error) {
+	confDir := opts.ExpandConfDir()
+	profiles := []string{}
+
+	err := filepath.WalkDir(confDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if !d.IsDir() || path == confDir {
+			return nil
+		}
+
+		if strings.HasPrefix(d.Name(), ".") {
+			return filepath.SkipDir
+		}
+
+		if opts.isProfileDir(path) {
+			rel, err := filepath.Rel(confDir, path)
+
+			if err != nil {
+				return err
+			}
+
+			profiles = append(profiles, filepath.ToSlash(rel))
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to list profiles: %w", err)
+	}
+
+	return profiles, nil
+}
+
+// NOTE: Return true if the overrides file cannot be evaluated so that the error is reported on load
+func (opts *DefinitionOpts) isProfileDir(dir string) bool {
+	if findEcspressoConfig(dir, opts) != "" {
+		return true
+	}
+
+	path := filepath.Join(dir, opts.OverridesFile)
+
+	if _, err := os.Stat(path); err != nil {
+		return false
+	}
+
+	overrides, err := newOoverrides(path)
+
+	return err != nil || overrides.getString("extends") != ""
+}
+
+func (opts *DefinitionOpts) load(profile string, command string, image string, cpu uint64, memory uint64, initProcessEnabled bool, trace *Trace) (*Definition, error) {
+	dirs, overrides, err := loadProfile(opts.profileDir(profile), opts)
 
 	if err != nil {
 		return nil, err
 	}
 
-	ecspressoConf, err := loadEcsecspressoConf(confDir, opts, overrides)
+	ecspressoConf, err := loadEcsecspressoConf(dirs, opts, overrides, trace)
 
 	if err != nil {
 		return nil, err
@@ -81,19 +171,19 @@ func (opts *DefinitionOpts) Load(profile string, command string, image string, c
 		taskDefFile = "ecs-task-def.jsonnet"
 	}
 
-	serviceDef, err := loadServiceDef(confDir, serviceDefFile, opts, overrides)
+	serviceDef, err := loadServiceDef(dirs, serviceDefFile, opts, overrides, trace)
 
 	if err != nil {
 		return nil, err
 	}
 
-	containerDef, err := loadContainerDef(confDir, taskDefFile, opts, overrides, command, image, initProcessEnabled)
+	containerDef, err := loadContainerDef(dirs, taskDefFile, opts, overrides, command, image, initProcessEnabled, trace)
 
 	if err != nil {
 		return nil, err
 	}
 
-	taskDef, err := loadTaskDef(confDir, taskDefFile, containerDef, opts, overrides, cpu, memory)
+	taskDef, err := loadTaskDef(dirs, taskDefFile, containerDef, opts, overrides, cpu, memory, trace)
 
 	if err != nil {
 		return nil, err
@@ -106,50 +196,39 @@ func (opts *DefinitionOpts) Load(profile string, command string, image string, c
 	}
 
 	return &Definition{
-		EcspressoConfig: ecspressoConf,
-		Service:         serviceDef,
-		Task:            taskDef,
-		Cluster:         cluster,
+		EcspressoConfig:      ecspressoConf,
+		Service:              serviceDef,
+		Task:                 taskDef,
+		Cluster:              cluster,
+		MainContainer:        containerDef.name(),
+		Overrides:            overrides,
+		origLogConfiguration: containerDef.origLogConfiguration,
 	}, nil
 }
 
-func loadOverridesFile(confDir string, opts *DefinitionOpts) (*Overrides, error) {
-	overrides, err := newOoverrides(filepath.Join(confDir, opts.OverridesFile))
-
-	if err != nil {
-		return nil, err
-	}
-
-	return overrides, nil
-}
-
-func loadEcsecspressoConf(confDir string, opts *DefinitionOpts, overrides *Overrides) (*EcspressoConfig, error) {
-	var cfgFile string
+func loadEcsecspressoConf(dirs []string, opts *DefinitionOpts, overrides *Overrides, trace *Trace) (*EcspressoConfig, error) {
+	cfgFiles := []string{}
 
-	for _, f := range opts.Config {
-		if _, err := os.Stat(filepath.Join(confDir, f)); err != nil {
-			continue
+	for _, dir := range dirs {
+		if cfgFile := findEcspressoConfig(dir, opts); cfgFile != "" {
+			cfgFiles = append(cfgFiles, cfgFile)
 		}
-
-		cfgFile = filepath.Join(confDir, f)
 	}
 
-	if cfgFile == "" {
-		return nil, fmt.Errorf("ecspresso config file not found: %s", filepath.Join(confDir, strings.Join(opts.Config, ",")))
+	if len(cfgFiles) == 0 {
+		return nil, fmt.Errorf("ecspresso config file not found: %s", filepath.Join(dirs[len(dirs)-1], strings.Join(opts.Config, ",")))
 	}
 
-	ecspressoConf, err := newEcspressoConfig(cfgFile)
+	ecspressoConf, err := newEcspressoConfig(cfgFiles, trace)
 
 	if err != nil {
 		return nil, err
 	}
 
-	if v := overrides.get("ecspresso_config"); v != "" {
-		err = ecspressoConf.patch(v)
+	err = overrides.patchEach("ecspresso_config", ecspressoConf.patch)
 
-		if err != nil {
-			return nil, err
-		}
+	if err != nil {
+		return nil, err
 	}
 
 	if opts.Cluster != "" {
@@ -161,14 +240,14 @@ func loadEcsecspressoConf(confDir string, opts *DefinitionOpts, overrides *Overr
 			panic(err)
 		}
 
-		err = ecspressoConf.patch(string(js))
+		err = ecspressoConf.patch(string(js), Layer{Name: "cli", Source: "--cluster"})
 
 		if err != nil {
 			return nil, err
 		}
 	}
 
-	err = ecspressoConf.patch(opts.ConfigOverrides)
+	err = ecspressoConf.patch(opts.ConfigOverrides, Layer{Name: "cli", Source: "-e/--config-overrides"})
 
 	if err != nil {
 		return nil, err
@@ -177,22 +256,26 @@ func loadEcsecspressoConf(confDir string, opts *DefinitionOpts, overrides *Overr
 	return ecspressoConf, nil
 }
 
-func loadServiceDef(confDir string, serviceDefFile string, opts *DefinitionOpts, overrides *Overrides) (*ServiceDefinition, error) {
-	serviceDef, err := newServiceDefinition(filepath.Join(confDir, serviceDefFile))
+func loadServiceDef(dirs []string, serviceDefFile string, opts *DefinitionOpts, overrides *Overrides, trace *Trace) (*ServiceDefinition, error) {
+	paths := chainFiles(dirs, serviceDefFile)
+
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("failed to load ECS service definition: file not found: %s", filepath.Join(dirs[len(dirs)-1], serviceDefFile))
+	}
+
+	serviceDef, err := newServiceDefinition(paths, trace)
 
 	if err != nil {
 		return nil, err
 	}
 
-	if v := overrides.get("service_definition"); v != "" {
-		err = serviceDef.patch(v)
+	err = overrides.patchEach("service_definition", serviceDef.patch)
 
-		if err != nil {
-			return nil, err
-		}
+	if err != nil {
+		return nil, err
 	}
 
-	err = serviceDef.patch(opts.ServiceOverrides)
+	err = serviceDef.patch(opts.ServiceOverrides, Layer{Name: "cli", Source: "-s/--service-overrides"})
 
 	if err != nil {
 		return nil, err
@@ -201,22 +284,38 @@ func loadServiceDef(confDir string, serviceDefFile string, opts *DefinitionOpts,
 	return serviceDef, nil
 }
 
-func loadTaskDef(confDir string, taskDefFile string, containerDef *ContainerDefinition, opts *DefinitionOpts, overrides *Overrides, cpu uint64, memory uint64) (*TaskDefinition, error) {
-	taskDef, err := newTaskDefinition(filepath.Join(confDir, taskDefFile))
+func loadTaskDef(dirs []string, taskDefFile string, containerDef *ContainerDefinition, opts *DefinitionOpts, overrides *Overrides, cpu uint64, memory uint64, trace *Trace) (*TaskDefinition, error) {
+	paths := chainFiles(dirs, taskDefFile)
+
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("failed to load ECS task definition: file not found: %s", filepath.Join(dirs[len(dirs)-1], taskDefFile))
+	}
+
+	taskDef, err := newTaskDefinition(paths, trace)
 
 	if err != nil {
 		return nil, err
 	}
 
-	if v := overrides.get("task_definition"); v != "" {
-		err = taskDef.patch(v, nil, 0, 0)
+	err = overrides.patchEach("task_definition", func(v string, layer Layer) error {
+		return taskDef.patch(v, layer, nil, nil, 0, 0)
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	sidecars := opts.Sidecars
+
+	if len(sidecars) == 0 {
+		sidecars, err = overrides.getStrings("sidecars")
 
 		if err != nil {
 			return nil, err
 		}
 	}
 
-	err = taskDef.patch(opts.TaskOverrides, containerDef, cpu, memory)
+	err = taskDef.patch(opts.TaskOverrides, Layer{Name: "cli", Source: "-t/--task-overrides"}, containerDef, sidecars, cpu, memory)
 
 	if err != nil {
 		return nil, err
@@ -225,22 +324,26 @@ func loadTaskDef(confDir string, taskDefFile string, containerDef *ContainerDefi
 	return taskDef, nil
 }
 
-func loadContainerDef(confDir string, taskDefFile string, opts *DefinitionOpts, overrides *Overrides, command string, image string, initProcessEnabled bool) (*ContainerDefinition, error) {
-	containerDef, err := newContainerDefinition(filepath.Join(confDir, opts.ContainerDef), filepath.Join(confDir, taskDefFile))
+func loadContainerDef(dirs []string, taskDefFile string, opts *DefinitionOpts, overrides *Overrides, command string, image string, initProcessEnabled bool, trace *Trace) (*ContainerDefinition, error) {
+	mainContainer := opts.MainContainer
+
+	if mainContainer == "" {
+		mainContainer = overrides.getString("main_container")
+	}
+
+	containerDef, err := newContainerDefinition(chainFiles(dirs, opts.ContainerDef), chainFiles(dirs, taskDefFile), mainContainer, trace)
 
 	if err != nil {
 		return nil, err
 	}
 
-	if v := overrides.get("container_definition"); v != "" {
-		err = containerDef.patch(v, "", "", false)
+	err = overrides.patchEach("container_definition", containerDef.merge)
 
-		if err != nil {
-			return nil, err
-		}
+	if err != nil {
+		return nil, err
 	}
 
-	err = containerDef.patch(opts.ConfigOverrides, command, image, initProcessEnabled)
+	err = containerDef.patch(opts.ContainerOverrides, Layer{Name: "cli", Source: "-c/--container-overrides"}, command, image, initProcessEnabled)
 
 	if err != nil {
 		return nil, err
@@ -249,6 +352,26 @@ func loadContainerDef(confDir string, taskDefFile string, opts *DefinitionOpts,
 	return containerDef, nil
 }
 
+// NOTE: The last existing file in opts.Config wins
+func findEcspressoConfig(dir string, opts *DefinitionOpts) string {
+	var cfgFile string
+
+	for _, f := range opts.Config {
+		if _, err := os.Stat(filepath.Join(dir, f)); err != nil {
+			continue
+		}
+
+		cfgFile = filepath.Join(dir, f)
+	}
+
+	return cfgFile
+}
+
+// Region returns the region in ecspresso config (empty if not set).
+func (def *Definition) Region() (string, error) {
+	return def.EcspressoConfig.get("region")
+}
+
 func (def *Definition) Print() {
 	ecspressoConf, err := utils.JSONToYAML(def.EcspressoConfig.Content)
 
@@ -12,9 +12,27 @@ import (
 
 type EcspressoConfig struct {
 	Content []byte
+	trace   *Trace
 }
 
-func newEcspressoConfig(path string) (*EcspressoConfig, error) {
+func newEcspressoConfig(paths []string, trace *Trace) (*EcspressoConfig, error) {
+	content, err := mergeFiles(paths, readEcspressoConfig, func(path string, content []byte) {
+		trace.record(targetEcspressoConfig, Layer{Name: "base", Source: path}, content)
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	ecsConf := &EcspressoConfig{
+		Content: content,
+		trace:   trace,
+	}
+
+	return ecsConf, nil
+}
+
+func readEcspressoConfig(path string) ([]byte, error) {
 	content, err := os.ReadFile(path)
 
 	if err != nil {
@@ -31,14 +49,10 @@ func newEcspressoConfig(path string) (*EcspressoConfig, error) {
 		return nil, fmt.Errorf("failed to parse ecspresso config: %w: %s", err, path)
 	}
 
-	ecsConf := &EcspressoConfig{
-		Content: content,
-	}
-
-	return ecsConf, nil
+	return content, nil
 }
 
-func (ecsConf *EcspressoConfig) patch(overrides string) error {
+func (ecsConf *EcspressoConfig) patch(overrides string, layer Layer) error {
 	overrides = strings.TrimSpace(overrides)
 
 	if overrides == "" {
@@ -52,6 +66,7 @@ func (ecsConf *EcspressoConfig) patch(overrides string) error {
 	}
 
 	ecsConf.Content = patchedContent
+	ecsConf.trace.record(targetEcspressoConfig, layer, patchedContent)
 
 	return nil
 }
@@ -0,0 +1,132 @@
+package definition
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	jsonpatch "github.com/evanphx/json-patch"
+)
+
+// loadProfile resolves the profile dirs declared by "extends" in the overrides file
+// and returns them from the root to the profile dir with the merged overrides.
+// NOTE: "extends" is relative to the dir of the overrides file
+func loadProfile(profileDir string, opts *DefinitionOpts) ([]string, *Overrides, error) {
+	dirs := []string{}
+	chain := []*Overrides{}
+	visited := map[string]bool{}
+	dir := filepath.Clean(profileDir)
+
+	for {
+		if visited[dir] {
+			return nil, nil, fmt.Errorf("circular 'extends' in overrides file: %s", filepath.Join(dir, opts.OverridesFile))
+		}
+
+		visited[dir] = true
+		overrides, err := newOoverrides(filepath.Join(dir, opts.OverridesFile))
+
+		if err != nil {
+			return nil, nil, err
+		}
+
+		err = overrides.validateTags()
+
+		if err != nil {
+			return nil, nil, err
+		}
+
+		dirs = append([]string{dir}, dirs...)
+		chain = append([]*Overrides{overrides}, chain...)
+		parent := overrides.getString("extends")
+
+		if parent == "" {
+			break
+		}
+
+		if !filepath.IsAbs(parent) {
+			parent = filepath.Join(dir, parent)
+		}
+
+		if info, err := os.Stat(parent); err != nil || !info.IsDir() {
+			return nil, nil, fmt.Errorf("profile dir in 'extends' not found: %s: %s", parent, overrides.path)
+		}
+
+		dir = filepath.Clean(parent)
+	}
+
+	var content []byte
+
+	for _, overrides := range chain {
+		if overrides.Content == nil {
+			continue
+		}
+
+		patch, err := jsonpatch.MergePatch(overrides.Content, []byte(`{"extends":null}`))
+
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to parse overrides file: %w: %s", err, overrides.path)
+		}
+
+		if content == nil {
+			content = patch
+			continue
+		}
+
+		content, err = jsonpatch.MergePatch(content, patch)
+
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to merge overrides file: %w: %s", err, overrides.path)
+		}
+	}
+
+	merged := &Overrides{
+		Content: content,
+		path:    chain[len(chain)-1].path,
+		chain:   chain,
+	}
+
+	return dirs, merged, nil
+}
+
+// chainFiles returns the existing files with the name in the profile dirs.
+func chainFiles(dirs []string, name string) []string {
+	paths := []string{}
+
+	for _, dir := range dirs {
+		path := filepath.Join(dir, name)
+
+		if _, err := os.Stat(path); err == nil {
+			paths = append(paths, path)
+		}
+	}
+
+	return paths
+}
+
+// mergeFiles reads the files and merges them in order.
+// NOTE: Arrays (e.g. containerDefinitions) are replaced, not merged
+func mergeFiles(paths []string, read func(string) ([]byte, error), record func(string, []byte)) ([]byte, error) {
+	var merged []byte
+
+	for _, path := range paths {
+		content, err := read(path)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if merged == nil {
+			merged = content
+		} else {
+			merged, err = jsonpatch.MergePatch(merged, content)
+
+			if err != nil {
+				return nil, fmt.Errorf("failed to merge: %w: %s", err, path)
+			}
+		}
+
+		record(path, merged)
+	}
+
+	return merged, nil
+}
@@ -0,0 +1,28 @@
+package definition
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+
+	jsonpatch "github.com/evanphx/json-patch"
+)
+
+// Hash returns a short hash of the merged definitions except tags.
+// Tasks launched from the same definitions have the same hash.
+func (def *Definition) Hash() (string, error) {
+	taskContent, err := jsonpatch.MergePatch(def.Task.Content, []byte(`{"tags":null}`))
+
+	if err != nil {
+		return "", fmt.Errorf("failed to remove tags from ECS task definition: %w", err)
+	}
+
+	hash := sha256.New()
+	hash.Write([]byte(def.Cluster))
+	hash.Write([]byte{0})
+	hash.Write(def.Service.Content)
+	hash.Write([]byte{0})
+	hash.Write(taskContent)
+
+	return hex.EncodeToString(hash.Sum(nil))[:16], nil
+}
@@ -0,0 +1,124 @@
+package definition
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/valyala/fastjson"
+)
+
+// LogConfig is the awslogs configuration of the main container.
+type LogConfig struct {
+	Group  string
+	Region string
+	// Log streams are "<prefix>/<container name>/<task ID>"
+	StreamPrefix string
+}
+
+// EnableLogs restores the awslogs configuration of the main container removed by demitas
+// (or injects a new one if the group is specified) with a stream prefix unique to this run.
+func (def *Definition) EnableLogs(group string) (*LogConfig, error) {
+	var p fastjson.Parser
+	v, err := p.ParseBytes(def.Task.Content)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse ECS task definition: %w", err)
+	}
+
+	containerDef := v.Get("containerDefinitions", "0")
+
+	if containerDef == nil {
+		return nil, fmt.Errorf("'containerDefinitions.0' is not found in ECS task definition")
+	}
+
+	// NOTE: logConfiguration in overrides takes precedence over the original one
+	logConf := containerDef.Get("logConfiguration")
+
+	if logConf == nil || logConf.Type() != fastjson.TypeObject {
+		logConf = nil
+
+		if def.origLogConfiguration != nil {
+			logConf, err = fastjson.ParseBytes(def.origLogConfiguration)
+
+			if err != nil {
+				panic(err)
+			}
+		}
+	}
+
+	var arena fastjson.Arena
+	options := arena.NewObject()
+	logDriver := ""
+
+	if logConf != nil {
+		logDriver = string(logConf.GetStringBytes("logDriver"))
+
+		if logDriver == "awslogs" && logConf.GetObject("options") != nil {
+			options = logConf.Get("options")
+		}
+	}
+
+	if group == "" {
+		if logDriver != "awslogs" {
+			return nil, fmt.Errorf("awslogs configuration is not found in ECS container definition (logDriver: %q), log group is required", logDriver)
+		}
+
+		group = string(options.GetStringBytes("awslogs-group"))
+
+		if group == "" {
+			return nil, fmt.Errorf("'awslogs-group' is not found in ECS container definition, log group is required")
+		}
+	}
+
+	region := string(options.GetStringBytes("awslogs-region"))
+
+	if region == "" {
+		region, err = def.EcspressoConfig.get("region")
+
+		if err != nil {
+			return nil, err
+		}
+
+		if region == "" {
+			return nil, fmt.Errorf("'awslogs-region' is not found in ECS container definition and 'region' is not found in ecspresso config")
+		}
+	}
+
+	config := &LogConfig{
+		Group:        group,
+		Region:       region,
+		StreamPrefix: newStreamPrefix(),
+	}
+
+	options.Set("awslogs-group", arena.NewString(config.Group))
+	options.Set("awslogs-region", arena.NewString(config.Region))
+	options.Set("awslogs-stream-prefix", arena.NewString(config.StreamPrefix))
+
+	newLogConf := arena.NewObject()
+	newLogConf.Set("logDriver", arena.NewString("awslogs"))
+	newLogConf.Set("options", options)
+
+	if logDriver == "awslogs" {
+		if secretOptions := logConf.Get("secretOptions"); secretOptions != nil {
+			newLogConf.Set("secretOptions", secretOptions)
+		}
+	}
+
+	containerDef.Set("logConfiguration", newLogConf)
+	def.Task.Content = v.MarshalTo(nil)
+
+	return config, nil
+}
+
+// NOTE: e.g. "dmts-alice-20240102150405-1a2b"
+func newStreamPrefix() string {
+	nonce := make([]byte, 2)
+
+	if _, err := rand.Read(nonce); err != nil {
+		panic(err)
+	}
+
+	return fmt.Sprintf("dmts-%s-%s-%s", Username(), time.Now().Format("20060102150405"), hex.EncodeToString(nonce))
+}
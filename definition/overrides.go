@@ -3,6 +3,9 @@ package definition
 import (
 	"fmt"
 	"os"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/kanmu/demitas2/utils"
 	"github.com/valyala/fastjson"
@@ -10,13 +13,16 @@ import (
 
 type Overrides struct {
 	Content []byte
+	path    string
+	// NOTE: Files of the "extends" chain from the root to the profile (nil for a single file)
+	chain []*Overrides
 }
 
 func newOoverrides(path string) (*Overrides, error) {
 	_, err := os.Stat(path)
 
 	if err != nil {
-		return &Overrides{}, nil
+		return &Overrides{path: path}, nil
 	}
 
 	content, err := utils.EvaluateJsonnet(path)
@@ -27,6 +33,7 @@ func newOoverrides(path string) (*Overrides, error) {
 
 	overrides := &Overrides{
 		Content: content,
+		path:    path,
 	}
 
 	return overrides, nil
@@ -43,3 +50,204 @@ func (overrides *Overrides) get(key string) string {
 		return ""
 	}
 }
+
+func (overrides *Overrides) getString(key string) string {
+	var p fastjson.Parser
+	content, _ := p.ParseBytes(overrides.Content)
+
+	if content == nil {
+		return ""
+	}
+
+	return string(content.GetStringBytes(key))
+}
+
+func (overrides *Overrides) getStrings(key string) ([]string, error) {
+	var p fastjson.Parser
+	content, _ := p.ParseBytes(overrides.Content)
+
+	if content == nil {
+		return nil, nil
+	}
+
+	v := content.Get(key)
+
+	if v == nil {
+		return nil, nil
+	}
+
+	arr, err := v.Array()
+
+	if err != nil {
+		return nil, fmt.Errorf("'%s' must be an array of strings in overrides file: %s", key, overrides.path)
+	}
+
+	strs := []string{}
+
+	for i, e := range arr {
+		bs, err := e.StringBytes()
+
+		if err != nil {
+			return nil, fmt.Errorf("'%s.%d' must be a string in overrides file: %s", key, i, overrides.path)
+		}
+
+		strs = append(strs, string(bs))
+	}
+
+	return strs, nil
+}
+
+func (overrides *Overrides) layer(key string) Layer {
+	return Layer{Name: "overrides", Source: overrides.path + "#" + key}
+}
+
+// patchEach calls patch with the value of the key in each file of the "extends" chain
+// so that the trace points to the file that set the field.
+func (overrides *Overrides) patchEach(key string, patch func(string, Layer) error) error {
+	chain := overrides.chain
+
+	if chain == nil {
+		chain = []*Overrides{overrides}
+	}
+
+	for _, o := range chain {
+		if v := o.get(key); v != "" {
+			err := patch(v, o.layer(key))
+
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// TTL returns the default maximum lifetime of debug tasks ("ttl").
+func (overrides *Overrides) TTL() (time.Duration, error) {
+	v := overrides.getString("ttl")
+
+	if v == "" {
+		return 0, nil
+	}
+
+	ttl, err := time.ParseDuration(v)
+
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse 'ttl' in overrides file: %w: %s", err, overrides.path)
+	}
+
+	return ttl, nil
+}
+
+// Description returns the description of the profile ("description").
+func (overrides *Overrides) Description() string {
+	return overrides.getString("description")
+}
+
+// Tags returns extra tags of launched tasks ("tags").
+// NOTE: Values other than strings are rejected by validateTags
+func (overrides *Overrides) Tags() map[string]string {
+	var p fastjson.Parser
+	content, _ := p.ParseBytes(overrides.Content)
+	tags := map[string]string{}
+
+	if content == nil {
+		return tags
+	}
+
+	obj := content.GetObject("tags")
+
+	if obj == nil {
+		return tags
+	}
+
+	obj.Visit(func(key []byte, v *fastjson.Value) {
+		if bs, err := v.StringBytes(); err == nil {
+			tags[string(key)] = string(bs)
+		}
+	})
+
+	return tags
+}
+
+// PortForwardTarget is a named target of port forwarding ("port_forwards").
+type PortForwardTarget struct {
+	Name       string `json:"name"`
+	Host       string `json:"host"`
+	RemotePort uint   `json:"remote_port"`
+	// Defaults to RemotePort
+	LocalPort uint `json:"local_port"`
+}
+
+// PortForwards returns the named targets of port forwarding sorted by name.
+func (overrides *Overrides) PortForwards() ([]*PortForwardTarget, error) {
+	var p fastjson.Parser
+	content, _ := p.ParseBytes(overrides.Content)
+	targets := []*PortForwardTarget{}
+
+	if content == nil {
+		return targets, nil
+	}
+
+	obj := content.GetObject("port_forwards")
+
+	if obj == nil {
+		return targets, nil
+	}
+
+	var err error
+
+	obj.Visit(func(key []byte, v *fastjson.Value) {
+		if err != nil {
+			return
+		}
+
+		target := &PortForwardTarget{
+			Name:       string(key),
+			Host:       string(v.GetStringBytes("host")),
+			RemotePort: v.GetUint("remote_port"),
+			LocalPort:  v.GetUint("local_port"),
+		}
+
+		if target.Host == "" || target.RemotePort == 0 {
+			err = fmt.Errorf("'port_forwards.%s' requires 'host' and 'remote_port' in overrides file: %s", target.Name, overrides.path)
+			return
+		}
+
+		if target.LocalPort == 0 {
+			target.LocalPort = target.RemotePort
+		}
+
+		targets = append(targets, target)
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(targets, func(i, j int) bool { return targets[i].Name < targets[j].Name })
+
+	return targets, nil
+}
+
+// PortForward returns the named target of port forwarding.
+func (overrides *Overrides) PortForward(name string) (*PortForwardTarget, error) {
+	targets, err := overrides.PortForwards()
+
+	if err != nil {
+		return nil, err
+	}
+
+	names := []string{}
+
+	for _, t := range targets {
+		if t.Name == name {
+			return t, nil
+		}
+
+		names = append(names, t.Name)
+	}
+
+	return nil, fmt.Errorf("port forwarding target '%s' not found (available: %s): %s", name, strings.Join(names, ", "), overrides.path)
+}
@@ -10,23 +10,37 @@ import (
 
 type ServiceDefinition struct {
 	Content []byte
+	trace   *Trace
 }
 
-func newServiceDefinition(path string) (*ServiceDefinition, error) {
-	content, err := utils.ReadJSONorJsonnet(path)
+func newServiceDefinition(paths []string, trace *Trace) (*ServiceDefinition, error) {
+	content, err := mergeFiles(paths, readServiceDefinition, func(path string, content []byte) {
+		trace.record(targetServiceDefinition, Layer{Name: "base", Source: path}, content)
+	})
 
 	if err != nil {
-		return nil, fmt.Errorf("failed to load ECS service definition: %w: %s", err, path)
+		return nil, err
 	}
 
 	svrDef := &ServiceDefinition{
 		Content: content,
+		trace:   trace,
 	}
 
 	return svrDef, nil
 }
 
-func (svrDef *ServiceDefinition) patch(overrides string) error {
+func readServiceDefinition(path string) ([]byte, error) {
+	content, err := utils.ReadJSONorJsonnet(path)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to load ECS service definition: %w: %s", err, path)
+	}
+
+	return content, nil
+}
+
+func (svrDef *ServiceDefinition) patch(overrides string, layer Layer) error {
 	overrides = strings.TrimSpace(overrides)
 
 	if overrides == "" {
@@ -39,12 +53,16 @@ func (svrDef *ServiceDefinition) patch(overrides string) error {
 		return fmt.Errorf("failed to patch ECS service definition: %w", err)
 	}
 
+	svrDef.trace.record(targetServiceDefinition, layer, patchedContent)
+
 	patchedContent, err = jsonpatch.MergePatch(patchedContent, []byte(`{"enableExecuteCommand": true}`))
 
 	if err != nil {
 		return fmt.Errorf("failed to enable ECS Exec: %w", err)
 	}
 
+	svrDef.trace.record(targetServiceDefinition, layerDemitasExecuteCommand, patchedContent)
+
 	svrDef.Content = patchedContent
 
 	return nil
@@ -0,0 +1,100 @@
+package definition
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/valyala/fastjson"
+)
+
+// Summary is the overview of a profile.
+type Summary struct {
+	Cluster     string `json:"cluster"`
+	Region      string `json:"region"`
+	Family      string `json:"family"`
+	Image       string `json:"image"`
+	Cpu         string `json:"cpu"`
+	Memory      string `json:"memory"`
+	LaunchType  string `json:"launch_type"`
+	Description string `json:"description"`
+	// Named targets of port forwarding sorted by name
+	PortForwards []*PortForwardTarget `json:"port_forwards"`
+}
+
+// Summary returns the overview of the merged definitions.
+// NOTE: The family is the original one without the "dmts-<user>-" prefix
+func (def *Definition) Summary() (*Summary, error) {
+	region, err := def.EcspressoConfig.get("region")
+
+	if err != nil {
+		return nil, err
+	}
+
+	var taskParser, serviceParser fastjson.Parser
+	task, err := taskParser.ParseBytes(def.Task.Content)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse ECS task definition: %w", err)
+	}
+
+	service, err := serviceParser.ParseBytes(def.Service.Content)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse ECS service definition: %w", err)
+	}
+
+	portForwards, err := def.Overrides.PortForwards()
+
+	if err != nil {
+		return nil, err
+	}
+
+	summary := &Summary{
+		Cluster:      def.Cluster,
+		Region:       region,
+		Family:       strings.TrimPrefix(string(task.GetStringBytes("family")), FamilyPrefix()),
+		Image:        mainContainerImage(task, def.MainContainer),
+		Cpu:          jsonScalar(task.Get("cpu")),
+		Memory:       jsonScalar(task.Get("memory")),
+		LaunchType:   string(service.GetStringBytes("launchType")),
+		Description:  def.Overrides.Description(),
+		PortForwards: portForwards,
+	}
+
+	// NOTE: launchType and capacityProviderStrategy are exclusive
+	if strategy := service.GetArray("capacityProviderStrategy"); len(strategy) > 0 {
+		providers := []string{}
+
+		for _, s := range strategy {
+			providers = append(providers, string(s.GetStringBytes("capacityProvider")))
+		}
+
+		summary.LaunchType = strings.Join(providers, ",")
+	}
+
+	return summary, nil
+}
+
+// NOTE: cpu and memory may be either strings or numbers
+func jsonScalar(v *fastjson.Value) string {
+	if v == nil {
+		return ""
+	}
+
+	if bs, err := v.StringBytes(); err == nil {
+		return string(bs)
+	}
+
+	return v.String()
+}
+
+// NOTE: Look up the main container by name instead of relying on the order of containerDefinitions
+func mainContainerImage(task *fastjson.Value, mainContainer string) string {
+	for _, c := range task.GetArray("containerDefinitions") {
+		if string(c.GetStringBytes("name")) == mainContainer {
+			return string(c.GetStringBytes("image"))
+		}
+	}
+
+	return string(task.GetStringBytes("containerDefinitions", "0", "image"))
+}
@@ -0,0 +1,159 @@
+package definition
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	jsonpatch "github.com/evanphx/json-patch"
+	"github.com/valyala/fastjson"
+)
+
+const (
+	TagUser       = "dmts:user"
+	TagProfile    = "dmts:profile"
+	TagVersion    = "dmts:version"
+	TagSubcommand = "dmts:subcommand"
+	TagHostname   = "dmts:hostname"
+	TagCommand    = "dmts:command"
+	TagRunId      = "dmts:run-id"
+	// Hash of the definition of a reusable task
+	TagReuse = "dmts:reuse"
+	// Last time a reusable task was used (RFC 3339)
+	TagLastUsed = "dmts:last-used"
+	// Idle timeout of a reusable task (e.g. "30m0s")
+	TagIdleTimeout = "dmts:idle-timeout"
+)
+
+const (
+	maxTagKeyLength   = 128
+	maxTagValueLength = 256
+	// NOTE: ECS allows at most 50 user-defined tags per resource
+	maxTags = 50
+)
+
+var invalidTagChars = regexp.MustCompile(`[^\p{L}\p{Z}\p{N}_.:/=+\-@]`)
+
+type tag struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// SetTags adds tags to the task definition and propagates them to the task.
+func (def *Definition) SetTags(tags map[string]string) error {
+	var p fastjson.Parser
+	v, err := p.ParseBytes(def.Task.Content)
+
+	if err != nil {
+		return fmt.Errorf("failed to parse ECS task definition: %w", err)
+	}
+
+	newTags := []tag{}
+
+	for _, t := range v.GetArray("tags") {
+		key := string(t.GetStringBytes("key"))
+
+		if _, ok := tags[key]; !ok {
+			newTags = append(newTags, tag{Key: key, Value: string(t.GetStringBytes("value"))})
+		}
+	}
+
+	keys := []string{}
+
+	for k := range tags {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		newTags = append(newTags, tag{Key: k, Value: sanitizeTagValue(tags[k])})
+	}
+
+	if len(newTags) > maxTags {
+		dmtsTags := 0
+
+		for _, t := range newTags {
+			if strings.HasPrefix(t.Key, "dmts:") {
+				dmtsTags++
+			}
+		}
+
+		return fmt.Errorf("too many tags in ECS task definition: %d tags including %d 'dmts:' tags (ECS allows at most %d)", len(newTags), dmtsTags, maxTags)
+	}
+
+	js, err := json.Marshal(map[string][]tag{"tags": newTags})
+
+	if err != nil {
+		panic(err)
+	}
+
+	patchedContent, err := jsonpatch.MergePatch(def.Task.Content, js)
+
+	if err != nil {
+		return fmt.Errorf("failed to update 'tags' in ECS task definition: %w", err)
+	}
+
+	def.Task.Content = patchedContent
+	patchedContent, err = jsonpatch.MergePatch(def.Service.Content, []byte(`{"propagateTags":"TASK_DEFINITION"}`))
+
+	if err != nil {
+		return fmt.Errorf("failed to update 'propagateTags' in ECS service definition: %w", err)
+	}
+
+	def.Service.Content = patchedContent
+
+	return nil
+}
+
+func sanitizeTagValue(value string) string {
+	value = invalidTagChars.ReplaceAllString(value, "_")
+
+	if runes := []rune(value); len(runes) > maxTagValueLength {
+		value = string(runes[:maxTagValueLength])
+	}
+
+	return value
+}
+
+// NOTE: Tag values are sanitized on SetTags, but invalid keys are rejected by ECS
+func (overrides *Overrides) validateTags() error {
+	var p fastjson.Parser
+	content, _ := p.ParseBytes(overrides.Content)
+
+	if obj := content.GetObject("tags"); obj != nil {
+		var err error
+
+		obj.Visit(func(key []byte, v *fastjson.Value) {
+			if err == nil && v.Type() != fastjson.TypeString {
+				err = fmt.Errorf("'tags.%s' must be a string in overrides file: %s", key, overrides.path)
+			}
+		})
+
+		if err != nil {
+			return err
+		}
+	}
+
+	for key, value := range overrides.Tags() {
+		if key == "" || len([]rune(key)) > maxTagKeyLength {
+			return fmt.Errorf("tag key must be 1 to %d characters: '%s' in overrides file: %s", maxTagKeyLength, key, overrides.path)
+		}
+
+		if invalidTagChars.MatchString(key) {
+			return fmt.Errorf("tag key contains invalid characters: '%s' in overrides file: %s", key, overrides.path)
+		}
+
+		if strings.HasPrefix(strings.ToLower(key), "aws:") {
+			return fmt.Errorf("tag key must not start with 'aws:': '%s' in overrides file: %s", key, overrides.path)
+		}
+
+		if len([]rune(value)) > maxTagValueLength {
+			return fmt.Errorf("tag value must be at most %d characters: '%s' in overrides file: %s", maxTagValueLength, key, overrides.path)
+		}
+	}
+
+	return nil
+}
@@ -15,29 +15,45 @@ import (
 
 type TaskDefinition struct {
 	Content []byte
+	trace   *Trace
 }
 
-func newTaskDefinition(path string) (*TaskDefinition, error) {
-	content, err := utils.ReadJSONorJsonnet(path)
+func newTaskDefinition(paths []string, trace *Trace) (*TaskDefinition, error) {
+	content, err := mergeFiles(paths, readTaskDefinition, func(path string, content []byte) {
+		trace.record(targetTaskDefinition, Layer{Name: "base", Source: path}, content)
+	})
 
 	if err != nil {
-		return nil, fmt.Errorf("failed to load ECS task definition: %w: %s", err, path)
+		return nil, err
 	}
 
 	patchedContent, err := patchContainerDefInLoad(content)
 
 	if err != nil {
-		return nil, fmt.Errorf("failed to patch ECS container definition in load: %w: %s", err, path)
+		return nil, fmt.Errorf("failed to patch ECS container definition in load: %w: %s", err, paths[len(paths)-1])
 	}
 
+	trace.record(targetTaskDefinition, layerDemitasFamily, patchedContent)
+
 	taskDef := &TaskDefinition{
 		Content: patchedContent,
+		trace:   trace,
 	}
 
 	return taskDef, nil
 }
 
-func (taskDef *TaskDefinition) patch(overrides string, containerDef *ContainerDefinition, cpu uint64, memory uint64) error {
+func readTaskDefinition(path string) ([]byte, error) {
+	content, err := utils.ReadJSONorJsonnet(path)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to load ECS task definition: %w: %s", err, path)
+	}
+
+	return content, nil
+}
+
+func (taskDef *TaskDefinition) patch(overrides string, layer Layer, containerDef *ContainerDefinition, sidecars []string, cpu uint64, memory uint64) error {
 	overrides = strings.TrimSpace(overrides)
 	patchedContent := taskDef.Content
 	var err error
@@ -48,15 +64,25 @@ func (taskDef *TaskDefinition) patch(overrides string, containerDef *ContainerDe
 		if err != nil {
 			return fmt.Errorf("failed to patch ECS task definition: %w", err)
 		}
+
+		taskDef.trace.record(targetTaskDefinition, layer, patchedContent)
 	}
 
 	if containerDef != nil {
-		containerDefinitions := fmt.Sprintf(`{"containerDefinitions":[%s]}`, string(containerDef.Content))
-		patchedContent, err = jsonpatch.MergePatch(patchedContent, []byte(containerDefinitions))
-	}
+		var containerDefinitions []byte
+		containerDefinitions, err = buildContainerDefinitions(patchedContent, containerDef, sidecars)
 
-	if err != nil {
-		return fmt.Errorf("failed to patch containerDefinitions: %w", err)
+		if err != nil {
+			return fmt.Errorf("failed to build containerDefinitions: %w", err)
+		}
+
+		patchedContent, err = jsonpatch.MergePatch(patchedContent, containerDefinitions)
+
+		if err != nil {
+			return fmt.Errorf("failed to patch containerDefinitions: %w", err)
+		}
+
+		taskDef.trace.record(targetTaskDefinition, Layer{Name: "container_definition", Source: "main container and sidecars"}, patchedContent)
 	}
 
 	if cpu != 0 {
@@ -65,6 +91,8 @@ func (taskDef *TaskDefinition) patch(overrides string, containerDef *ContainerDe
 		if err != nil {
 			return fmt.Errorf("failed to update 'cpu' in ECS taks definition: %w", err)
 		}
+
+		taskDef.trace.record(targetTaskDefinition, Layer{Name: "cli", Source: "--cpu"}, patchedContent)
 	}
 
 	if memory != 0 {
@@ -73,6 +101,8 @@ func (taskDef *TaskDefinition) patch(overrides string, containerDef *ContainerDe
 		if err != nil {
 			return fmt.Errorf("failed to update 'memory' in ECS taks definition: %w", err)
 		}
+
+		taskDef.trace.record(targetTaskDefinition, Layer{Name: "cli", Source: "--memory"}, patchedContent)
 	}
 
 	taskDef.Content = patchedContent
@@ -80,6 +110,22 @@ func (taskDef *TaskDefinition) patch(overrides string, containerDef *ContainerDe
 	return nil
 }
 
+// Username returns the current user name without non-word characters.
+func Username() string {
+	currUser, err := user.Current()
+
+	if err != nil {
+		panic(err)
+	}
+
+	return regexp.MustCompile(`\W+`).ReplaceAllString(currUser.Username, "")
+}
+
+// FamilyPrefix returns the task definition family prefix of the current user (e.g. "dmts-alice-").
+func FamilyPrefix() string {
+	return "dmts-" + Username() + "-"
+}
+
 func patchContainerDefInLoad(content []byte) ([]byte, error) {
 	var p fastjson.Parser
 	v, err := p.ParseBytes(content)
@@ -98,19 +144,81 @@ func patchContainerDefInLoad(content []byte) ([]byte, error) {
 		return content, nil
 	}
 
-	currUser, err := user.Current()
+	patch := fmt.Sprintf(`{"family":"%s%s"}`, FamilyPrefix(), string(family))
+	patchedContent, err := jsonpatch.MergePatch(content, []byte(patch))
 
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 
-	username := regexp.MustCompile(`\W+`).ReplaceAllString(currUser.Username, "")
-	patch := fmt.Sprintf(`{"family":"dmts-%s-%s"}`, username, string(family))
-	patchedContent, err := jsonpatch.MergePatch(content, []byte(patch))
+	return patchedContent, nil
+}
+
+// NOTE: The main container comes first, followed by the sidecars kept from the task definition
+func buildContainerDefinitions(taskContent []byte, containerDef *ContainerDefinition, sidecars []string) ([]byte, error) {
+	var taskParser, mainParser fastjson.Parser
+	taskValue, err := taskParser.ParseBytes(taskContent)
 
 	if err != nil {
 		return nil, err
 	}
 
-	return patchedContent, nil
+	mainValue, err := mainParser.ParseBytes(containerDef.Content)
+
+	if err != nil {
+		return nil, err
+	}
+
+	containers := []*fastjson.Value{mainValue}
+	names := map[string]bool{string(mainValue.GetStringBytes("name")): true}
+
+	for _, name := range sidecars {
+		if names[name] {
+			continue
+		}
+
+		var sidecar *fastjson.Value
+
+		for _, c := range taskValue.GetArray("containerDefinitions") {
+			if string(c.GetStringBytes("name")) == name {
+				sidecar = c
+				break
+			}
+		}
+
+		if sidecar == nil {
+			return nil, fmt.Errorf("sidecar container '%s' is not found in ECS task definition", name)
+		}
+
+		containers = append(containers, sidecar)
+		names[name] = true
+	}
+
+	// NOTE: Ignore dependsOn on containers that are not kept
+	var arena fastjson.Arena
+	strContainers := []string{}
+
+	for _, c := range containers {
+		if deps := c.GetArray("dependsOn"); deps != nil {
+			keptDeps := arena.NewArray()
+			n := 0
+
+			for _, d := range deps {
+				if names[string(d.GetStringBytes("containerName"))] {
+					keptDeps.SetArrayItem(n, d)
+					n++
+				}
+			}
+
+			if n > 0 {
+				c.Set("dependsOn", keptDeps)
+			} else {
+				c.Del("dependsOn")
+			}
+		}
+
+		strContainers = append(strContainers, c.String())
+	}
+
+	return []byte(`{"containerDefinitions":[` + strings.Join(strContainers, ",") + `]}`), nil
 }
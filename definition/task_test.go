@@ -0,0 +1,121 @@
+package definition
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/valyala/fastjson"
+)
+
+const testTaskDef = `{
+  "family": "my-app",
+  "containerDefinitions": [
+    {"name": "app", "image": "app", "dependsOn": [{"containerName": "envoy", "condition": "HEALTHY"}, {"containerName": "log-router", "condition": "START"}]},
+    {"name": "envoy", "image": "envoy"},
+    {"name": "log-router", "image": "fluent-bit"},
+    {"name": "worker", "image": "worker", "dependsOn": [{"containerName": "log-router", "condition": "START"}]}
+  ]
+}`
+
+func TestBuildContainerDefinitions(t *testing.T) {
+	tests := []struct {
+		name          string
+		mainContainer string
+		sidecars      []string
+		// Container names and the containerName of their dependsOn
+		expected []string
+		err      string
+	}{
+		{
+			name:     "default first container",
+			expected: []string{"app"},
+		},
+		{
+			name:          "named main container",
+			mainContainer: "worker",
+			expected:      []string{"worker"},
+		},
+		{
+			name:     "sidecar keeps dependsOn on kept containers",
+			sidecars: []string{"envoy"},
+			expected: []string{"app:envoy", "envoy"},
+		},
+		{
+			name:          "sidecars of named main container",
+			mainContainer: "worker",
+			sidecars:      []string{"log-router", "worker"},
+			expected:      []string{"worker:log-router", "log-router"},
+		},
+		{
+			name:     "unknown sidecar",
+			sidecars: []string{"datadog"},
+			err:      "sidecar container 'datadog' is not found in ECS task definition",
+		},
+		{
+			name:          "unknown main container",
+			mainContainer: "datadog",
+			err:           "container 'datadog' is not found in ECS task definition",
+		},
+	}
+
+	taskDefPath := filepath.Join(t.TempDir(), "ecs-task-def.json")
+	err := os.WriteFile(taskDefPath, []byte(testTaskDef), 0644)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			containerDef, err := newContainerDefinition(nil, []string{taskDefPath}, tt.mainContainer, nil)
+
+			if err == nil {
+				var content []byte
+				content, err = buildContainerDefinitions([]byte(testTaskDef), containerDef, tt.sidecars)
+
+				if err == nil {
+					if actual := containerNames(t, content); strings.Join(actual, ",") != strings.Join(tt.expected, ",") {
+						t.Errorf("expected %v, got %v", tt.expected, actual)
+					}
+				}
+			}
+
+			if tt.err == "" && err != nil {
+				t.Fatal(err)
+			} else if tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+				t.Fatalf("expected error %q, got %v", tt.err, err)
+			}
+		})
+	}
+}
+
+// NOTE: Return "name:dependency,..." of each container
+func containerNames(t *testing.T, content []byte) []string {
+	t.Helper()
+	v, err := fastjson.ParseBytes(content)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names := []string{}
+
+	for _, c := range v.GetArray("containerDefinitions") {
+		name := string(c.GetStringBytes("name"))
+		deps := []string{}
+
+		for _, d := range c.GetArray("dependsOn") {
+			deps = append(deps, string(d.GetStringBytes("containerName")))
+		}
+
+		if len(deps) > 0 {
+			name += ":" + strings.Join(deps, ",")
+		}
+
+		names = append(names, name)
+	}
+
+	return names
+}
@@ -0,0 +1,174 @@
+package definition
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/valyala/fastjson"
+)
+
+const (
+	targetEcspressoConfig     = "ecspresso_config"
+	targetServiceDefinition   = "service_definition"
+	targetTaskDefinition      = "task_definition"
+	targetContainerDefinition = "container_definition"
+)
+
+var targetAliases = map[string]string{
+	"config":    targetEcspressoConfig,
+	"service":   targetServiceDefinition,
+	"task":      targetTaskDefinition,
+	"container": targetContainerDefinition,
+}
+
+// Layer is where a merge step comes from.
+type Layer struct {
+	// "base", "overrides", "cli" or "demitas"
+	Name string
+	// File path, option name or description of the patch
+	Source string
+}
+
+var (
+	layerDemitasLogConfiguration = Layer{Name: "demitas", Source: `{"logConfiguration":null}`}
+	layerDemitasInitProcess      = Layer{Name: "demitas", Source: `{"linuxParameters":{"initProcessEnabled":true}}`}
+	layerDemitasEnv              = Layer{Name: "demitas", Source: `DEMITAS=true environment`}
+	layerDemitasFamily           = Layer{Name: "demitas", Source: `"dmts-<user>-" family prefix`}
+	layerDemitasExecuteCommand   = Layer{Name: "demitas", Source: `{"enableExecuteCommand":true}`}
+	layerDemitasMainContainer    = Layer{Name: "demitas", Source: `main container name`}
+)
+
+// TraceStep is a snapshot of a definition after a merge step.
+type TraceStep struct {
+	Target  string
+	Layer   Layer
+	Content []byte
+}
+
+// Trace records every merge step of DefinitionOpts.Load.
+type Trace struct {
+	Steps []*TraceStep
+}
+
+func (trace *Trace) record(target string, layer Layer, content []byte) {
+	if trace == nil {
+		return
+	}
+
+	trace.Steps = append(trace.Steps, &TraceStep{
+		Target:  target,
+		Layer:   layer,
+		Content: content,
+	})
+}
+
+// Change is a merge step that changed the value of a field.
+type Change struct {
+	Target string
+	Layer  Layer
+	// JSON value (empty if the field is removed)
+	Value string
+}
+
+type Explanation struct {
+	Path    string
+	Changes []*Change
+}
+
+// Value returns the final value of the field.
+func (expl *Explanation) Value() string {
+	if len(expl.Changes) == 0 {
+		return ""
+	}
+
+	return expl.Changes[len(expl.Changes)-1].Value
+}
+
+// Explain traces a field (e.g. "task_definition.containerDefinitions[0].image") through the merge steps.
+func (trace *Trace) Explain(path string) (*Explanation, error) {
+	target, keys, err := parsePath(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	expl := &Explanation{
+		Path: strings.Join(append([]string{target}, keys...), "."),
+	}
+
+	// NOTE: task_definition.containerDefinitions is replaced by the container definition
+	if target == targetTaskDefinition && len(keys) >= 2 && keys[0] == "containerDefinitions" && keys[1] == "0" {
+		expl.Changes = trace.changes(targetContainerDefinition, keys[2:])
+	} else {
+		expl.Changes = trace.changes(target, keys)
+	}
+
+	return expl, nil
+}
+
+func (trace *Trace) changes(target string, keys []string) []*Change {
+	changes := []*Change{}
+	prev := ""
+
+	for _, step := range trace.Steps {
+		if step.Target != target {
+			continue
+		}
+
+		var p fastjson.Parser
+		v, err := p.ParseBytes(step.Content)
+
+		if err != nil {
+			continue
+		}
+
+		value := ""
+
+		if field := v.Get(keys...); field != nil {
+			value = field.String()
+		}
+
+		if value == prev {
+			continue
+		}
+
+		changes = append(changes, &Change{
+			Target: target,
+			Layer:  step.Layer,
+			Value:  value,
+		})
+
+		prev = value
+	}
+
+	return changes
+}
+
+func parsePath(path string) (string, []string, error) {
+	path = strings.TrimPrefix(strings.TrimSpace(path), "$.")
+	path = regexp.MustCompile(`\[(\d+)\]`).ReplaceAllString(path, ".$1")
+	keys := strings.Split(path, ".")
+
+	target := keys[0]
+
+	if alias, ok := targetAliases[target]; ok {
+		target = alias
+	}
+
+	switch target {
+	case targetEcspressoConfig, targetServiceDefinition, targetTaskDefinition, targetContainerDefinition:
+	default:
+		return "", nil, fmt.Errorf("path must start with ecspresso_config, service_definition, task_definition or container_definition: %s", path)
+	}
+
+	keys = keys[1:]
+
+	for _, k := range keys {
+		if k == "" {
+			return "", nil, fmt.Errorf("invalid path: %s", path)
+		}
+	}
+
+	return target, keys, nil
+}
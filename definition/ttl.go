@@ -0,0 +1,80 @@
+package definition
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/valyala/fastjson"
+)
+
+const TTLEnvName = "DEMITAS_TTL"
+
+// SetTTL makes the main container exit after the TTL.
+// "sleep infinity" is replaced with "sleep <seconds>" and other commands are wrapped with "timeout <seconds>".
+// The TTL is also set to the DEMITAS_TTL environment variable.
+// Wrapped commands require "timeout" in the image (distroless and scratch images do not have it).
+func (def *Definition) SetTTL(ttl time.Duration) error {
+	// NOTE: TTLs under 1s become "sleep 0"
+	if ttl < time.Second {
+		return fmt.Errorf("TTL must be at least 1s: %s", ttl)
+	}
+
+	var p fastjson.Parser
+	v, err := p.ParseBytes(def.Task.Content)
+
+	if err != nil {
+		return fmt.Errorf("failed to parse ECS task definition: %w", err)
+	}
+
+	containerDef := v.Get("containerDefinitions", "0")
+
+	if containerDef == nil {
+		return fmt.Errorf("'containerDefinitions.0' is not found in ECS task definition")
+	}
+
+	var arena fastjson.Arena
+	secs := strconv.FormatInt(int64(ttl.Seconds()), 10)
+	command := containerDef.GetArray("command")
+
+	if len(command) == 0 {
+		return fmt.Errorf("'command' is required in ECS container definition to set TTL")
+	}
+
+	newCommand := arena.NewArray()
+
+	if len(command) == 2 && string(command[0].GetStringBytes()) == "sleep" && string(command[1].GetStringBytes()) == "infinity" {
+		newCommand.SetArrayItem(0, arena.NewString("sleep"))
+		newCommand.SetArrayItem(1, arena.NewString(secs))
+	} else {
+		newCommand.SetArrayItem(0, arena.NewString("timeout"))
+		newCommand.SetArrayItem(1, arena.NewString(secs))
+
+		for i, c := range command {
+			newCommand.SetArrayItem(i+2, c)
+		}
+	}
+
+	containerDef.Set("command", newCommand)
+
+	envs := containerDef.GetArray("environment")
+	newEnvs := arena.NewArray()
+	n := 0
+
+	for _, e := range envs {
+		if string(e.GetStringBytes("name")) != TTLEnvName {
+			newEnvs.SetArrayItem(n, e)
+			n++
+		}
+	}
+
+	ttlEnv := arena.NewObject()
+	ttlEnv.Set("name", arena.NewString(TTLEnvName))
+	ttlEnv.Set("value", arena.NewString(secs))
+	newEnvs.SetArrayItem(n, ttlEnv)
+	containerDef.Set("environment", newEnvs)
+
+	def.Task.Content = v.MarshalTo(nil)
+
+	return nil
+}
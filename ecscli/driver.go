@@ -7,30 +7,45 @@ import (
 	"os/exec"
 	"os/signal"
 	"strings"
-	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs"
 	"github.com/aws/aws-sdk-go-v2/service/ecs"
-	"github.com/kanmu/demitas2/utils"
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
 )
 
 type Driver struct {
+	// Use session-manager-plugin (aws ssm start-session) for port forwarding instead of the built-in client
+	UseSessionManagerPlugin bool
+	// Polling interval while waiting for ECS Exec to be ready
+	ReadyBackoff Backoff
+
 	client *ecs.Client
+	ssm    *ssm.Client
+	logs   *cloudwatchlogs.Client
 }
 
 func NewDriver(cfg aws.Config) *Driver {
 	return &Driver{
-		client: ecs.NewFromConfig(cfg),
+		ReadyBackoff: DefaultReadyBackoff,
+		client:       ecs.NewFromConfig(cfg),
+		ssm:          ssm.NewFromConfig(cfg),
+		logs:         cloudwatchlogs.NewFromConfig(cfg),
 	}
 }
 
 func (dri *Driver) StopTask(cluster string, taskId string) error {
+	return stopTask(dri.client, cluster, taskId)
+}
+
+func stopTask(client *ecs.Client, cluster string, taskId string) error {
 	input := &ecs.StopTaskInput{
 		Cluster: aws.String(cluster),
 		Task:    aws.String(taskId),
 	}
 
-	_, err := dri.client.StopTask(context.Background(), input)
+	_, err := client.StopTask(context.Background(), input)
 
 	if err != nil {
 		return fmt.Errorf("faild to call StopTask: %s/%s", cluster, taskId)
@@ -39,91 +54,150 @@ func (dri *Driver) StopTask(cluster string, taskId string) error {
 	return nil
 }
 
-func (dri *Driver) GetContainerId(cluster string, taskId string) (string, error) {
+func (dri *Driver) DescribeTask(cluster string, taskId string) (*types.Task, error) {
 	input := &ecs.DescribeTasksInput{
 		Cluster: aws.String(cluster),
 		Tasks:   []string{taskId},
+		Include: []types.TaskField{types.TaskFieldTags},
 	}
 
 	output, err := dri.client.DescribeTasks(context.Background(), input)
 
 	if err != nil {
-		return "", fmt.Errorf("faild to call DescribeTasks: %s/%s", taskId, cluster)
+		return nil, fmt.Errorf("faild to call DescribeTasks: %s/%s", taskId, cluster)
 	}
 
 	if len(output.Tasks) == 0 {
-		return "", fmt.Errorf("task not found: %s/%s", taskId, cluster)
+		return nil, fmt.Errorf("task not found: %s/%s", taskId, cluster)
 	}
 
-	task := output.Tasks[0]
+	return &output.Tasks[0], nil
+}
 
-	if len(task.Containers) == 0 {
-		return "", fmt.Errorf("container not found: %s/%s", taskId, cluster)
+func (dri *Driver) TagTask(cluster string, taskId string, tags map[string]string) error {
+	task, err := dri.DescribeTask(cluster, taskId)
+
+	if err != nil {
+		return err
+	}
+
+	input := &ecs.TagResourceInput{
+		ResourceArn: task.TaskArn,
+	}
+
+	for k, v := range tags {
+		input.Tags = append(input.Tags, types.Tag{Key: aws.String(k), Value: aws.String(v)})
+	}
+
+	_, err = dri.client.TagResource(context.Background(), input)
+
+	if err != nil {
+		return fmt.Errorf("faild to call TagResource: %s/%s: %w", taskId, cluster, err)
 	}
 
-	return *task.Containers[0].RuntimeId, nil
+	return nil
 }
 
-func (dri *Driver) StartPortForwardingSessionToRemoteHost(cluster string, taskId string, containerId string, remoteHost string, remotePort uint, localPort uint) error {
-	target := fmt.Sprintf("ecs:%s_%s_%s", cluster, taskId, containerId)
-	params := fmt.Sprintf(`{"host":["%s"],"portNumber":["%d"],"localPortNumber":["%d"]}`, remoteHost, remotePort, localPort)
+func (dri *Driver) ListTasks(cluster string) ([]types.Task, error) {
+	taskArns := []string{}
+	paginator := ecs.NewListTasksPaginator(dri.client, &ecs.ListTasksInput{
+		Cluster:       aws.String(cluster),
+		DesiredStatus: types.DesiredStatusRunning,
+	})
 
-	cmdWithArgs := []string{
-		"aws", "ssm", "start-session",
-		"--target", target,
-		"--document-name", "AWS-StartPortForwardingSessionToRemoteHost",
-		"--parameters", params,
+	for paginator.HasMorePages() {
+		output, err := paginator.NextPage(context.Background())
+
+		if err != nil {
+			return nil, fmt.Errorf("faild to call ListTasks: %s: %w", cluster, err)
+		}
+
+		taskArns = append(taskArns, output.TaskArns...)
 	}
 
-	var err error
+	tasks := []types.Task{}
 
-	for i := 0; i < 30; i++ {
-		var stdout string
+	// NOTE: DescribeTasks accepts up to 100 tasks
+	for i := 0; i < len(taskArns); i += 100 {
+		input := &ecs.DescribeTasksInput{
+			Cluster: aws.String(cluster),
+			Tasks:   taskArns[i:min(i+100, len(taskArns))],
+			Include: []types.TaskField{types.TaskFieldTags},
+		}
 
-		// NOTE: https://github.com/kanmu/demitas2/issues/2
-		stdout, _, _, err = utils.RunCommand(cmdWithArgs, true)
+		output, err := dri.client.DescribeTasks(context.Background(), input)
 
 		if err != nil {
-			break
+			return nil, fmt.Errorf("faild to call DescribeTasks: %s: %w", cluster, err)
 		}
 
-		if !strings.Contains(stdout, "Terminate signal received, exiting.") {
-			fmt.Fprintf(os.Stderr, "Faild to start session: %s\nRetrying...\n", strings.TrimSpace(stdout))
-			time.Sleep(1 * time.Second)
+		tasks = append(tasks, output.Tasks...)
+	}
+
+	return tasks, nil
+}
+
+func (dri *Driver) DescribeTaskDefinition(taskDefArn string) (*types.TaskDefinition, error) {
+	input := &ecs.DescribeTaskDefinitionInput{
+		TaskDefinition: aws.String(taskDefArn),
+	}
+
+	output, err := dri.client.DescribeTaskDefinition(context.Background(), input)
+
+	if err != nil {
+		return nil, fmt.Errorf("faild to call DescribeTaskDefinition: %s: %w", taskDefArn, err)
+	}
+
+	return output.TaskDefinition, nil
+}
+
+// NOTE: Return the first container if the name is empty
+func (dri *Driver) GetContainerId(cluster string, taskId string, container string) (string, error) {
+	task, err := dri.DescribeTask(cluster, taskId)
+
+	if err != nil {
+		return "", err
+	}
+
+	if len(task.Containers) == 0 {
+		return "", fmt.Errorf("container not found: %s/%s", taskId, cluster)
+	}
+
+	if container == "" {
+		return aws.ToString(task.Containers[0].RuntimeId), nil
+	}
+
+	names := []string{}
+
+	for _, c := range task.Containers {
+		if aws.ToString(c.Name) == container {
+			return aws.ToString(c.RuntimeId), nil
 		}
+
+		names = append(names, aws.ToString(c.Name))
 	}
 
-	return err
+	return "", fmt.Errorf("container '%s' not found in task (available: %s): %s/%s", container, strings.Join(names, ", "), taskId, cluster)
 }
 
-func buildExecuteCommand(cluster string, taskId string, command string) []string {
-	return []string{
+func buildExecuteCommand(cluster string, taskId string, container string, command string) []string {
+	cmdWithArgs := []string{
 		"aws", "ecs", "execute-command",
 		"--cluster", cluster,
 		"--task", taskId,
 		"--interactive",
 		"--command", command,
 	}
-}
-
-func (dri *Driver) ExecuteCommand(cluster string, taskId string, command string) error {
-	cmdWithArgs := buildExecuteCommand(cluster, taskId, command)
-	stdout, stderr, _, err := utils.RunCommand(cmdWithArgs, true)
 
-	if err != nil {
-		if stdout != "" || stderr != "" {
-			errmsg := strings.TrimSpace(stdout + "\n" + stderr)
-			err = fmt.Errorf("%w: %s", err, errmsg)
-		}
-
-		return err
+	if container != "" {
+		cmdWithArgs = append(cmdWithArgs, "--container", container)
 	}
 
-	return nil
+	return cmdWithArgs
 }
 
-func (dri *Driver) ExecuteInteractiveCommand(cluster string, taskId string, command string) error {
-	cmdWithArgs := buildExecuteCommand(cluster, taskId, command)
+func (dri *Driver) ExecuteInteractiveCommand(cluster string, taskId string, container string, command string) error {
+	cmdWithArgs := buildExecuteCommand(cluster, taskId, container, command)
 	shell := exec.Command(cmdWithArgs[0], cmdWithArgs[1:]...)
 	shell.Stdin = os.Stdin
 	shell.Stdout = os.Stdout
@@ -0,0 +1,213 @@
+package ecscli
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecs"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	"github.com/kanmu/demitas2/ssmsession"
+	"github.com/kanmu/demitas2/utils"
+)
+
+// ExecuteNonInteractiveCommand runs the command on the container, writes the output and returns the exit code.
+// If in is not nil, it is written to the TTY followed by EOF (Ctrl-D), so it must be text terminated by a newline.
+// NOTE: ECS Exec always allocates a TTY, so stdout and stderr are merged
+func (dri *Driver) ExecuteNonInteractiveCommand(cluster string, taskId string, container string, command string, in io.Reader, out io.Writer) (int, error) {
+	marker := newExitCodeMarker()
+	script := nonInteractiveScript(command, marker)
+
+	input := &ecs.ExecuteCommandInput{
+		Cluster:     aws.String(cluster),
+		Task:        aws.String(taskId),
+		Command:     aws.String("sh -c " + utils.ShellQuote(script)),
+		Interactive: true,
+	}
+
+	if container != "" {
+		input.Container = aws.String(container)
+	}
+
+	output, err := dri.client.ExecuteCommand(context.Background(), input)
+
+	if err != nil {
+		return 0, fmt.Errorf("faild to call ExecuteCommand: %s/%s: %w", taskId, cluster, err)
+	}
+
+	sessionId := aws.ToString(output.Session.SessionId)
+
+	defer func() {
+		_, _ = dri.ssm.TerminateSession(context.Background(), &ssm.TerminateSessionInput{
+			SessionId: aws.String(sessionId),
+		})
+	}()
+
+	sess, err := ssmsession.Open(context.Background(), aws.ToString(output.Session.StreamUrl), aws.ToString(output.Session.TokenValue))
+
+	if err != nil {
+		return 0, err
+	}
+
+	defer sess.Close()
+
+	exitCode, err := runNonInteractiveCommand(sess, marker, in, out)
+
+	if err != nil {
+		return 0, fmt.Errorf("%w: %s/%s", err, taskId, cluster)
+	}
+
+	return exitCode, nil
+}
+
+// NOTE: Writing the input may finish after the output if the command exits without reading all of it
+var inputWriteTimeout = 10 * time.Second
+
+type nonInteractiveSession interface {
+	io.ReadWriter
+	ExitCode() (int, bool)
+}
+
+func runNonInteractiveCommand(sess nonInteractiveSession, marker string, in io.Reader, out io.Writer) (int, error) {
+	inErr := make(chan error, 1)
+
+	if in != nil {
+		go func() {
+			_, err := io.Copy(sess, in)
+
+			if err == nil {
+				_, err = sess.Write([]byte{eot})
+			}
+
+			inErr <- err
+		}()
+	} else {
+		inErr <- nil
+	}
+
+	w := &exitCodeWriter{out: out, marker: []byte(marker)}
+	_, err := io.Copy(w, sess)
+
+	if err != nil {
+		return 0, fmt.Errorf("failed to read output: %w", err)
+	}
+
+	select {
+	case err = <-inErr:
+		if err != nil {
+			return 0, fmt.Errorf("failed to write input: %w", err)
+		}
+	case <-time.After(inputWriteTimeout):
+		return 0, errors.New("session closed before input is written")
+	}
+
+	w.flush()
+
+	if w.exitCode == nil {
+		if exitCode, ok := sess.ExitCode(); ok {
+			return exitCode, nil
+		}
+
+		return 0, errors.New("exit code not found in output (the session may be disconnected)")
+	}
+
+	return *w.exitCode, nil
+}
+
+// NOTE: Disable echo and CRLF conversion of the TTY, and print the exit code at the end.
+// The command runs in a subshell so that the marker is printed even if it calls exit
+// (the newline ends a trailing comment)
+func nonInteractiveScript(command string, marker string) string {
+	return fmt.Sprintf("stty -echo -onlcr 2>/dev/null; ( %s\n); echo \"%s$?\"", command, marker)
+}
+
+// NOTE: Ctrl-D
+const eot = 0x04
+
+func newExitCodeMarker() string {
+	nonce := make([]byte, 8)
+
+	if _, err := rand.Read(nonce); err != nil {
+		panic(err)
+	}
+
+	return "__DMTS_EXIT_CODE_" + hex.EncodeToString(nonce) + "__="
+}
+
+// exitCodeWriter writes the output except the exit code line.
+type exitCodeWriter struct {
+	out      io.Writer
+	marker   []byte
+	buf      []byte
+	exitCode *int
+}
+
+func (w *exitCodeWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+
+	for {
+		i := bytes.Index(w.buf, w.marker)
+
+		if i < 0 {
+			break
+		}
+
+		end := bytes.IndexByte(w.buf[i:], '\n')
+
+		if end < 0 {
+			// NOTE: Wait for the rest of the exit code line
+			_, err := w.out.Write(w.buf[:i])
+			w.buf = w.buf[i:]
+			return len(p), err
+		}
+
+		line := strings.TrimSpace(string(w.buf[i+len(w.marker) : i+end]))
+
+		if exitCode, err := strconv.Atoi(line); err == nil {
+			w.exitCode = &exitCode
+		}
+
+		_, err := w.out.Write(w.buf[:i])
+
+		if err != nil {
+			return len(p), err
+		}
+
+		w.buf = w.buf[i+end+1:]
+	}
+
+	// NOTE: Keep the tail that may be the beginning of the marker
+	keep := 0
+
+	for n := min(len(w.marker)-1, len(w.buf)); n > 0; n-- {
+		if bytes.HasSuffix(w.buf, w.marker[:n]) {
+			keep = n
+			break
+		}
+	}
+
+	_, err := w.out.Write(w.buf[:len(w.buf)-keep])
+	w.buf = w.buf[len(w.buf)-keep:]
+
+	return len(p), err
+}
+
+func (w *exitCodeWriter) flush() {
+	if i := bytes.Index(w.buf, w.marker); i >= 0 {
+		if exitCode, err := strconv.Atoi(strings.TrimSpace(string(w.buf[i+len(w.marker):]))); err == nil {
+			w.exitCode = &exitCode
+			w.buf = w.buf[:i]
+		}
+	}
+
+	_, _ = w.out.Write(w.buf)
+	w.buf = nil
+}
@@ -0,0 +1,115 @@
+package ecscli
+
+import (
+	"bytes"
+	"io"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNonInteractiveScript(t *testing.T) {
+	tests := []struct {
+		command  string
+		output   string
+		exitCode int
+	}{
+		{command: "echo hello", output: "hello\n", exitCode: 0},
+		{command: "echo hello; exit 3", output: "hello\n", exitCode: 3},
+		{command: "false # comment", output: "", exitCode: 1},
+	}
+
+	for _, tt := range tests {
+		marker := newExitCodeMarker()
+		out, err := exec.Command("sh", "-c", nonInteractiveScript(tt.command, marker)).Output()
+
+		if err != nil {
+			t.Fatalf("%s: %s", tt.command, err)
+		}
+
+		var buf bytes.Buffer
+		w := &exitCodeWriter{out: &buf, marker: []byte(marker)}
+		_, _ = w.Write(out)
+		w.flush()
+
+		if buf.String() != tt.output {
+			t.Errorf("%s: unexpected output: %q", tt.command, buf.String())
+		}
+
+		if w.exitCode == nil || *w.exitCode != tt.exitCode {
+			t.Errorf("%s: unexpected exit code: %v", tt.command, w.exitCode)
+		}
+	}
+}
+
+// fakeSession returns the output and then EOF, and blocks writes until the write delay elapses or unblock is closed.
+type fakeSession struct {
+	output     *strings.Reader
+	writeDelay time.Duration
+	unblock    chan struct{}
+	written    bytes.Buffer
+}
+
+func (sess *fakeSession) Read(p []byte) (int, error) {
+	return sess.output.Read(p)
+}
+
+func (sess *fakeSession) Write(p []byte) (int, error) {
+	select {
+	case <-time.After(sess.writeDelay):
+	case <-sess.unblock:
+		return 0, io.ErrClosedPipe
+	}
+
+	return sess.written.Write(p)
+}
+
+func (sess *fakeSession) ExitCode() (int, bool) {
+	return 0, false
+}
+
+func TestRunNonInteractiveCommandWaitsForInput(t *testing.T) {
+	marker := newExitCodeMarker()
+	sess := &fakeSession{
+		output:     strings.NewReader("hello\n" + marker + "3\n"),
+		writeDelay: 50 * time.Millisecond,
+		unblock:    make(chan struct{}),
+	}
+
+	var out bytes.Buffer
+	exitCode, err := runNonInteractiveCommand(sess, marker, strings.NewReader("input\n"), &out)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if exitCode != 3 || out.String() != "hello\n" {
+		t.Errorf("unexpected result: %d: %q", exitCode, out.String())
+	}
+
+	if sess.written.String() != "input\n\x04" {
+		t.Errorf("unexpected input: %q", sess.written.String())
+	}
+}
+
+func TestRunNonInteractiveCommandExitsBeforeInputIsWritten(t *testing.T) {
+	orig := inputWriteTimeout
+	inputWriteTimeout = 100 * time.Millisecond
+	t.Cleanup(func() { inputWriteTimeout = orig })
+
+	marker := newExitCodeMarker()
+	sess := &fakeSession{
+		output:     strings.NewReader(marker + "0\n"),
+		writeDelay: time.Hour,
+		unblock:    make(chan struct{}),
+	}
+
+	defer close(sess.unblock)
+
+	_, err := runNonInteractiveCommand(sess, marker, strings.NewReader("input\n"), io.Discard)
+
+	if err == nil || !strings.Contains(err.Error(), "session closed before input is written") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
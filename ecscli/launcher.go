@@ -0,0 +1,242 @@
+package ecscli
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecs"
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
+	"github.com/kanmu/demitas2/definition"
+	"github.com/valyala/fastjson"
+)
+
+const (
+	waitUntilRunningTimeout = 10 * time.Minute
+	waitUntilStoppedTimeout = 24 * time.Hour
+)
+
+// NOTE: Fields of ECS service definition used by RunTask
+type serviceDefinition struct {
+	CapacityProviderStrategy []types.CapacityProviderStrategyItem
+	EnableECSManagedTags     bool
+	EnableExecuteCommand     bool
+	LaunchType               types.LaunchType
+	NetworkConfiguration     *types.NetworkConfiguration
+	PlacementConstraints     []types.PlacementConstraint
+	PlacementStrategy        []types.PlacementStrategy
+	PlatformVersion          *string
+	PropagateTags            types.PropagateTags
+}
+
+func (dri *Driver) RunUntilRunning(def *definition.Definition, dryRun bool) (taskId string, interrupted bool, err error) {
+	return dri.run(def, dryRun, true)
+}
+
+func (dri *Driver) RunUntilStopped(def *definition.Definition, dryRun bool) (taskId string, interrupted bool, err error) {
+	return dri.run(def, dryRun, false)
+}
+
+func (dri *Driver) run(def *definition.Definition, dryRun bool, untilRunning bool) (taskId string, interrupted bool, err error) {
+	if dryRun {
+		def.Print()
+		fmt.Println()
+		return
+	}
+
+	region, err := def.Region()
+
+	if err != nil {
+		return
+	}
+
+	client := dri.regionalClient(region)
+	taskDefArn, err := registerTaskDefinition(client, def.Task)
+
+	if err != nil {
+		return
+	}
+
+	fmt.Printf("Task definition is registered: %s\n", taskDefArn)
+	taskId, err = runTask(client, def.Cluster, taskDefArn, def.Service)
+
+	if err != nil {
+		return
+	}
+
+	sigCtx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	input := &ecs.DescribeTasksInput{
+		Cluster: aws.String(def.Cluster),
+		Tasks:   []string{taskId},
+	}
+
+	if untilRunning {
+		fmt.Printf("Waiting for task ID %s until running\n", taskId)
+		err = ecs.NewTasksRunningWaiter(client).Wait(sigCtx, input, waitUntilRunningTimeout)
+	} else {
+		fmt.Printf("Waiting for task ID %s until stopped\n", taskId)
+		err = ecs.NewTasksStoppedWaiter(client).Wait(sigCtx, input, waitUntilStoppedTimeout)
+	}
+
+	if sigCtx.Err() != nil {
+		fmt.Printf("Stopping task: %s\n", taskId)
+		return taskId, true, stopTask(client, def.Cluster, taskId)
+	}
+
+	if err != nil {
+		if reason := getStoppedReason(client, def.Cluster, taskId); reason != "" {
+			err = fmt.Errorf("%w: %s", err, reason)
+		}
+
+		return taskId, false, fmt.Errorf("failed to wait for task: %w: %s/%s", err, def.Cluster, taskId)
+	}
+
+	return
+}
+
+// NOTE: Use the region in ecspresso config as ecspresso does
+func (dri *Driver) regionalClient(region string) *ecs.Client {
+	if region == "" {
+		return dri.client
+	}
+
+	return ecs.New(dri.client.Options(), func(o *ecs.Options) {
+		o.Region = region
+	})
+}
+
+func registerTaskDefinition(client *ecs.Client, taskDef *definition.TaskDefinition) (string, error) {
+	content, err := normalizeTaskDefinition(taskDef.Content)
+
+	if err != nil {
+		return "", err
+	}
+
+	input := &ecs.RegisterTaskDefinitionInput{}
+	err = json.Unmarshal(content, input)
+
+	if err != nil {
+		return "", fmt.Errorf("failed to parse ECS task definition: %w", err)
+	}
+
+	output, err := client.RegisterTaskDefinition(context.Background(), input)
+
+	if err != nil {
+		return "", fmt.Errorf("faild to call RegisterTaskDefinition: %w", err)
+	}
+
+	return *output.TaskDefinition.TaskDefinitionArn, nil
+}
+
+func runTask(client *ecs.Client, cluster string, taskDefArn string, svrDef *definition.ServiceDefinition) (string, error) {
+	sv := &serviceDefinition{}
+	err := json.Unmarshal(svrDef.Content, sv)
+
+	if err != nil {
+		return "", fmt.Errorf("failed to parse ECS service definition: %w", err)
+	}
+
+	input := &ecs.RunTaskInput{
+		Cluster:                  aws.String(cluster),
+		TaskDefinition:           aws.String(taskDefArn),
+		Count:                    aws.Int32(1),
+		CapacityProviderStrategy: sv.CapacityProviderStrategy,
+		EnableECSManagedTags:     sv.EnableECSManagedTags,
+		EnableExecuteCommand:     sv.EnableExecuteCommand,
+		NetworkConfiguration:     sv.NetworkConfiguration,
+		PlacementConstraints:     sv.PlacementConstraints,
+		PlacementStrategy:        sv.PlacementStrategy,
+		PlatformVersion:          sv.PlatformVersion,
+		PropagateTags:            sv.PropagateTags,
+	}
+
+	// NOTE: launchType and capacityProviderStrategy are exclusive
+	if len(sv.CapacityProviderStrategy) == 0 {
+		input.LaunchType = sv.LaunchType
+	}
+
+	output, err := client.RunTask(context.Background(), input)
+
+	if err != nil {
+		return "", fmt.Errorf("faild to call RunTask: %w", err)
+	}
+
+	if len(output.Failures) > 0 {
+		reasons := []string{}
+
+		for _, f := range output.Failures {
+			reasons = append(reasons, fmt.Sprintf("%s: %s", aws.ToString(f.Reason), aws.ToString(f.Detail)))
+		}
+
+		return "", errors.New("failed to run task: " + strings.Join(reasons, ", "))
+	}
+
+	if len(output.Tasks) == 0 {
+		return "", fmt.Errorf("task not found in RunTask output: %s", cluster)
+	}
+
+	taskArn := aws.ToString(output.Tasks[0].TaskArn)
+
+	return taskArn[strings.LastIndex(taskArn, "/")+1:], nil
+}
+
+func getStoppedReason(client *ecs.Client, cluster string, taskId string) string {
+	output, err := client.DescribeTasks(context.Background(), &ecs.DescribeTasksInput{
+		Cluster: aws.String(cluster),
+		Tasks:   []string{taskId},
+	})
+
+	if err != nil || len(output.Tasks) == 0 {
+		return ""
+	}
+
+	return aws.ToString(output.Tasks[0].StoppedReason)
+}
+
+// NOTE: ecspresso accepts numbers for the task cpu/memory and strings for the container cpu/memory,
+// but RegisterTaskDefinitionInput does not
+func normalizeTaskDefinition(content []byte) ([]byte, error) {
+	var p fastjson.Parser
+	v, err := p.ParseBytes(content)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse ECS task definition: %w", err)
+	}
+
+	var arena fastjson.Arena
+
+	for _, key := range []string{"cpu", "memory"} {
+		if f := v.Get(key); f != nil && f.Type() == fastjson.TypeNumber {
+			v.Set(key, arena.NewString(f.String()))
+		}
+	}
+
+	for _, c := range v.GetArray("containerDefinitions") {
+		for _, key := range []string{"cpu", "memory", "memoryReservation"} {
+			f := c.Get(key)
+
+			if f == nil || f.Type() != fastjson.TypeString {
+				continue
+			}
+
+			n, err := strconv.Atoi(string(f.GetStringBytes()))
+
+			if err != nil {
+				return nil, fmt.Errorf("'%s' of container must be a number in ECS task definition: %w", key, err)
+			}
+
+			c.Set(key, arena.NewNumberInt(n))
+		}
+	}
+
+	return v.MarshalTo(nil), nil
+}
@@ -0,0 +1,151 @@
+package ecscli
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecs"
+	"github.com/kanmu/demitas2/definition"
+)
+
+// fakeEcsEndpoint responds to the ECS API calls by the target (e.g. "RunTask") and records the requests.
+type fakeEcsEndpoint struct {
+	mu       sync.Mutex
+	requests map[string]map[string]any
+	regions  []string
+	status   string
+}
+
+func (fake *fakeEcsEndpoint) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	target := r.Header.Get("X-Amz-Target")
+	target = target[strings.LastIndex(target, ".")+1:]
+	body, _ := io.ReadAll(r.Body)
+	req := map[string]any{}
+	_ = json.Unmarshal(body, &req)
+
+	// NOTE: The credential scope is "<date>/<region>/ecs/aws4_request"
+	region := ""
+
+	if scope := strings.Split(r.Header.Get("Authorization"), "/"); len(scope) > 2 {
+		region = scope[2]
+	}
+
+	fake.mu.Lock()
+	fake.requests[target] = req
+	fake.regions = append(fake.regions, region)
+	fake.mu.Unlock()
+
+	taskArn := "arn:aws:ecs:ap-northeast-1:123456789012:task/my-cluster/0123456789abcdef"
+	w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+
+	switch target {
+	case "RegisterTaskDefinition":
+		w.Write([]byte(`{"taskDefinition":{"taskDefinitionArn":"arn:aws:ecs:ap-northeast-1:123456789012:task-definition/my-app:1"}}`)) //nolint:errcheck
+	case "RunTask":
+		w.Write([]byte(`{"tasks":[{"taskArn":"` + taskArn + `"}]}`)) //nolint:errcheck
+	case "DescribeTasks":
+		w.Write([]byte(`{"tasks":[{"taskArn":"` + taskArn + `","lastStatus":"` + fake.status + `"}]}`)) //nolint:errcheck
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"__type":"InvalidParameterException","message":"unexpected call"}`)) //nolint:errcheck
+	}
+}
+
+func newTestDriver(t *testing.T, status string) (*Driver, *fakeEcsEndpoint) {
+	t.Helper()
+	fake := &fakeEcsEndpoint{requests: map[string]map[string]any{}, status: status}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	dri := &Driver{
+		client: ecs.New(ecs.Options{
+			Region:       "us-east-1",
+			BaseEndpoint: aws.String(server.URL),
+			Credentials: aws.CredentialsProviderFunc(func(context.Context) (aws.Credentials, error) {
+				return aws.Credentials{AccessKeyID: "AKID", SecretAccessKey: "SECRET"}, nil
+			}),
+		}),
+	}
+
+	return dri, fake
+}
+
+func newTestDefinition() *definition.Definition {
+	return &definition.Definition{
+		EcspressoConfig: &definition.EcspressoConfig{Content: []byte(`{"region":"ap-northeast-1","cluster":"my-cluster"}`)},
+		Service:         &definition.ServiceDefinition{Content: []byte(`{"launchType":"FARGATE","enableExecuteCommand":true}`)},
+		Task: &definition.TaskDefinition{Content: []byte(`{
+			"family": "my-app",
+			"cpu": 256,
+			"memory": 512,
+			"containerDefinitions": [{"name": "app", "image": "debian", "memoryReservation": "128"}]
+		}`)},
+		Cluster: "my-cluster",
+	}
+}
+
+func TestRunUntilRunning(t *testing.T) {
+	dri, fake := newTestDriver(t, "RUNNING")
+
+	taskId, interrupted, err := dri.RunUntilRunning(newTestDefinition(), false)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if taskId != "0123456789abcdef" || interrupted {
+		t.Errorf("unexpected result: %s, %v", taskId, interrupted)
+	}
+
+	reg := fake.requests["RegisterTaskDefinition"]
+
+	if reg["cpu"] != "256" || reg["memory"] != "512" {
+		t.Errorf("cpu/memory is not normalized: %v", reg)
+	}
+
+	if c := reg["containerDefinitions"].([]any)[0].(map[string]any); c["memoryReservation"] != float64(128) {
+		t.Errorf("memoryReservation is not normalized: %v", c)
+	}
+
+	run := fake.requests["RunTask"]
+
+	if run["taskDefinition"] != "arn:aws:ecs:ap-northeast-1:123456789012:task-definition/my-app:1" || run["launchType"] != "FARGATE" || run["enableExecuteCommand"] != true {
+		t.Errorf("unexpected RunTask input: %v", run)
+	}
+
+	if _, ok := fake.requests["DescribeTasks"]; !ok {
+		t.Errorf("task is not waited")
+	}
+
+	for _, region := range fake.regions {
+		if region != "ap-northeast-1" {
+			t.Errorf("region in ecspresso config is not used: %v", fake.regions)
+			break
+		}
+	}
+}
+
+func TestRunUntilStopped(t *testing.T) {
+	dri, fake := newTestDriver(t, "STOPPED")
+
+	taskId, _, err := dri.RunUntilStopped(newTestDefinition(), false)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if taskId != "0123456789abcdef" {
+		t.Errorf("unexpected task ID: %s", taskId)
+	}
+
+	if _, ok := fake.requests["DescribeTasks"]; !ok {
+		t.Errorf("task is not waited")
+	}
+}
@@ -0,0 +1,103 @@
+package ecscli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs/types"
+)
+
+var (
+	tailLogsInterval = 2 * time.Second
+	// NOTE: Logs are delivered to CloudWatch Logs with a delay
+	tailLogsGracePeriod = 10 * time.Second
+)
+
+// TailLogs writes log events of the streams with the prefix until the context is canceled,
+// and keeps reading for a grace period after that.
+func (dri *Driver) TailLogs(ctx context.Context, region string, group string, streamPrefix string, out io.Writer) error {
+	startTime := time.Now().Add(-1 * time.Minute).UnixMilli()
+	// NOTE: Event ID to timestamp
+	seen := map[string]int64{}
+	var graceDeadline time.Time
+
+	for {
+		lastTime, err := dri.filterLogEvents(region, group, streamPrefix, startTime, seen, out)
+
+		if err != nil {
+			return err
+		}
+
+		// NOTE: Events with the last timestamp are returned again in the next call
+		if lastTime > startTime {
+			startTime = lastTime
+
+			for eventId, ts := range seen {
+				if ts < startTime {
+					delete(seen, eventId)
+				}
+			}
+		}
+
+		if graceDeadline.IsZero() {
+			select {
+			case <-ctx.Done():
+				graceDeadline = time.Now().Add(tailLogsGracePeriod)
+			case <-time.After(tailLogsInterval):
+			}
+		} else if time.Now().After(graceDeadline) {
+			return nil
+		} else {
+			time.Sleep(tailLogsInterval)
+		}
+	}
+}
+
+func (dri *Driver) filterLogEvents(region string, group string, streamPrefix string, startTime int64, seen map[string]int64, out io.Writer) (int64, error) {
+	input := &cloudwatchlogs.FilterLogEventsInput{
+		LogGroupName:        aws.String(group),
+		LogStreamNamePrefix: aws.String(streamPrefix + "/"),
+		StartTime:           aws.Int64(startTime),
+	}
+
+	paginator := cloudwatchlogs.NewFilterLogEventsPaginator(dri.logs, input)
+	lastTime := startTime
+
+	for paginator.HasMorePages() {
+		output, err := paginator.NextPage(context.Background(), func(o *cloudwatchlogs.Options) {
+			if region != "" {
+				o.Region = region
+			}
+		})
+
+		// NOTE: The log group is not created until the container writes the first log
+		var notFound *types.ResourceNotFoundException
+
+		if errors.As(err, &notFound) {
+			return lastTime, nil
+		}
+
+		if err != nil {
+			return 0, fmt.Errorf("faild to call FilterLogEvents: %s: %w", group, err)
+		}
+
+		for _, event := range output.Events {
+			eventId := aws.ToString(event.EventId)
+
+			if _, ok := seen[eventId]; ok {
+				continue
+			}
+
+			seen[eventId] = aws.ToInt64(event.Timestamp)
+			fmt.Fprintln(out, aws.ToString(event.Message))
+			lastTime = max(lastTime, aws.ToInt64(event.Timestamp))
+		}
+	}
+
+	return lastTime, nil
+}
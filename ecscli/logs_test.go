@@ -0,0 +1,71 @@
+package ecscli
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs"
+	"go.uber.org/atomic"
+)
+
+func TestTailLogsWaitsForLogGroup(t *testing.T) {
+	tailLogsInterval = 10 * time.Millisecond
+	tailLogsGracePeriod = 50 * time.Millisecond
+	calls := atomic.NewInt32(0)
+
+	// NOTE: The log group is created on the third call
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+
+		if calls.Inc() < 3 {
+			w.Header().Set("X-Amzn-Errortype", "ResourceNotFoundException")
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"__type":"ResourceNotFoundException","message":"The specified log group does not exist."}`)) //nolint:errcheck
+			return
+		}
+
+		w.Write([]byte(`{"events":[{"eventId":"1","message":"hello","timestamp":` + strconv.FormatInt(time.Now().UnixMilli(), 10) + `}]}`)) //nolint:errcheck
+	}))
+	defer server.Close()
+
+	dri := &Driver{
+		logs: cloudwatchlogs.New(cloudwatchlogs.Options{
+			Region:       "us-east-1",
+			BaseEndpoint: aws.String(server.URL),
+			Credentials:  aws.AnonymousCredentials{},
+		}),
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var out bytes.Buffer
+	done := make(chan error, 1)
+
+	go func() {
+		done <- dri.TailLogs(ctx, "", "my-group", "ecs/app", &out)
+	}()
+
+	for calls.Load() < 3 {
+		select {
+		case err := <-done:
+			t.Fatalf("TailLogs returned before the log group was created: %v", err)
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+
+	cancel()
+	err := <-done
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if out.String() != "hello\n" {
+		t.Errorf("unexpected output: %q", out.String())
+	}
+}
@@ -0,0 +1,234 @@
+package ecscli
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	"github.com/kanmu/demitas2"
+	"github.com/kanmu/demitas2/ssmsession"
+	"github.com/kanmu/demitas2/utils"
+	"go.uber.org/atomic"
+)
+
+type portForwardingStarter struct {
+	client     *ssm.Client
+	target     string
+	remoteHost string
+	remotePort uint
+	localPort  uint
+}
+
+func (starter *portForwardingStarter) StartSession(ctx context.Context) (*ssmsession.StartSessionOutput, error) {
+	input := &ssm.StartSessionInput{
+		Target:       aws.String(starter.target),
+		DocumentName: aws.String("AWS-StartPortForwardingSessionToRemoteHost"),
+		Parameters: map[string][]string{
+			"host":            {starter.remoteHost},
+			"portNumber":      {fmt.Sprint(starter.remotePort)},
+			"localPortNumber": {fmt.Sprint(starter.localPort)},
+		},
+	}
+
+	output, err := starter.client.StartSession(ctx, input)
+
+	if err != nil {
+		return nil, fmt.Errorf("faild to call StartSession: %s: %w", starter.target, err)
+	}
+
+	return &ssmsession.StartSessionOutput{
+		SessionId:  aws.ToString(output.SessionId),
+		StreamUrl:  aws.ToString(output.StreamUrl),
+		TokenValue: aws.ToString(output.TokenValue),
+	}, nil
+}
+
+func (starter *portForwardingStarter) TerminateSession(ctx context.Context, sessionId string) error {
+	_, err := starter.client.TerminateSession(ctx, &ssm.TerminateSessionInput{
+		SessionId: aws.String(sessionId),
+	})
+
+	if err != nil {
+		return fmt.Errorf("faild to call TerminateSession: %s: %w", sessionId, err)
+	}
+
+	return nil
+}
+
+// StartPortForwardingSessions forwards local ports to the remote hosts through the container until the context is canceled.
+// ready is closed when all local ports accept connections.
+func (dri *Driver) StartPortForwardingSessions(ctx context.Context, cluster string, taskId string, containerId string, forwards []demitas2.PortForward, ready chan<- struct{}) error {
+	target := fmt.Sprintf("ecs:%s_%s_%s", cluster, taskId, containerId)
+	readyN := newReadyCounter(len(forwards), ready)
+
+	if dri.UseSessionManagerPlugin {
+		return startPortForwardingSessionsWithPlugin(ctx, target, forwards, readyN)
+	}
+
+	listeners := []net.Listener{}
+
+	defer func() {
+		for _, l := range listeners {
+			l.Close()
+		}
+	}()
+
+	starters := []*portForwardingStarter{}
+
+	for _, pf := range forwards {
+		listener, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", pf.LocalPort))
+
+		if err != nil {
+			return fmt.Errorf("failed to listen on local port: %w: %s", err, pf)
+		}
+
+		listeners = append(listeners, listener)
+		starter := &portForwardingStarter{
+			client:     dri.ssm,
+			target:     target,
+			remoteHost: pf.RemoteHost,
+			remotePort: pf.RemotePort,
+			localPort:  pf.LocalPort,
+		}
+
+		// NOTE: Check that the session can be started before accepting connections
+		output, err := starter.StartSession(ctx)
+
+		if err != nil {
+			return err
+		}
+
+		_ = starter.TerminateSession(ctx, output.SessionId)
+		starters = append(starters, starter)
+		// NOTE: The listener accepts connections after the session check
+		readyN.done()
+	}
+
+	ctx, stop := context.WithCancel(ctx)
+	defer stop()
+
+	var wg sync.WaitGroup
+	errs := make([]error, len(forwards))
+
+	for i, pf := range forwards {
+		fmt.Fprintf(os.Stderr, "Forwarding 127.0.0.1:%d -> %s:%d\n", pf.LocalPort, pf.RemoteHost, pf.RemotePort)
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+			err := ssmsession.ForwardPort(ctx, listeners[i], starters[i])
+
+			if err != nil {
+				errs[i] = fmt.Errorf("%w: %s", err, pf)
+				fmt.Fprintf(os.Stderr, "Stopped forwarding %s: %s\n", pf, err)
+				// NOTE: Stop all forwards if one of them fails
+				stop()
+			}
+		}()
+	}
+
+	fmt.Fprintln(os.Stderr, "Waiting for connections...")
+	wg.Wait()
+
+	return errors.Join(errs...)
+}
+
+// readyCounter closes the channel when all port forwards are ready.
+type readyCounter struct {
+	remaining *atomic.Int32
+	ready     chan<- struct{}
+}
+
+func newReadyCounter(n int, ready chan<- struct{}) *readyCounter {
+	return &readyCounter{remaining: atomic.NewInt32(int32(n)), ready: ready}
+}
+
+func (counter *readyCounter) done() {
+	if counter.remaining.Dec() == 0 && counter.ready != nil {
+		close(counter.ready)
+	}
+}
+
+// pluginReadyWriter calls done once when session-manager-plugin starts listening on the local port.
+type pluginReadyWriter struct {
+	buf  []byte
+	once sync.Once
+	done func()
+}
+
+func (w *pluginReadyWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+
+	if bytes.Contains(w.buf, []byte("Waiting for connections")) {
+		w.once.Do(w.done)
+		w.buf = nil
+	} else if i := bytes.LastIndexByte(w.buf, '\n'); i >= 0 {
+		w.buf = w.buf[i+1:]
+	}
+
+	return len(p), nil
+}
+
+func startPortForwardingSessionsWithPlugin(ctx context.Context, target string, forwards []demitas2.PortForward, readyN *readyCounter) error {
+	var wg sync.WaitGroup
+	errs := make([]error, len(forwards))
+
+	for i, pf := range forwards {
+		fmt.Fprintf(os.Stderr, "Forwarding 127.0.0.1:%d -> %s:%d\n", pf.LocalPort, pf.RemoteHost, pf.RemotePort)
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+			err := startPortForwardingSessionWithPlugin(ctx, target, pf, &pluginReadyWriter{done: readyN.done})
+
+			if err != nil {
+				errs[i] = fmt.Errorf("%w: %s", err, pf)
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	return errors.Join(errs...)
+}
+
+// NOTE: ready is written with the output of session-manager-plugin
+func startPortForwardingSessionWithPlugin(ctx context.Context, target string, pf demitas2.PortForward, ready io.Writer) error {
+	params := fmt.Sprintf(`{"host":["%s"],"portNumber":["%d"],"localPortNumber":["%d"]}`, pf.RemoteHost, pf.RemotePort, pf.LocalPort)
+
+	cmdWithArgs := []string{
+		"aws", "ssm", "start-session",
+		"--target", target,
+		"--document-name", "AWS-StartPortForwardingSessionToRemoteHost",
+		"--parameters", params,
+	}
+
+	var err error
+
+	for i := 0; i < 30 && ctx.Err() == nil; i++ {
+		var stdout string
+
+		// NOTE: https://github.com/kanmu/demitas2/issues/2
+		stdout, _, _, err = utils.RunCommandContextTee(ctx, cmdWithArgs, true, ready)
+
+		if err != nil || ctx.Err() != nil {
+			break
+		}
+
+		if !strings.Contains(stdout, "Terminate signal received, exiting.") {
+			fmt.Fprintf(os.Stderr, "Faild to start session: %s\nRetrying...\n", strings.TrimSpace(stdout))
+			time.Sleep(1 * time.Second)
+		}
+	}
+
+	return err
+}
@@ -0,0 +1,167 @@
+package ecscli
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecs"
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+)
+
+// NOTE: ECS Exec may reject commands for a while after the agent becomes RUNNING
+const readinessProbeAttempts = 10
+
+// Backoff is the polling interval that doubles from Min up to Max.
+type Backoff struct {
+	Min time.Duration
+	Max time.Duration
+}
+
+var DefaultReadyBackoff = Backoff{Min: 1 * time.Second, Max: 10 * time.Second}
+
+func (backoff Backoff) next(interval time.Duration) time.Duration {
+	if interval <= 0 {
+		return backoff.Min
+	}
+
+	return min(interval*2, backoff.Max)
+}
+
+// WaitForExecuteCommandAgent waits until the ExecuteCommandAgent of the container is RUNNING
+// and ECS Exec accepts a command.
+// NOTE: Wait for the first container if the name is empty
+func (dri *Driver) WaitForExecuteCommandAgent(ctx context.Context, cluster string, taskId string, container string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	interval := dri.ReadyBackoff.next(0)
+	lastStatus := "(none)"
+
+	for {
+		task, err := dri.DescribeTask(cluster, taskId)
+
+		if err != nil {
+			return err
+		}
+
+		ready, status, err := executeCommandAgentStatus(task, container)
+
+		if err != nil {
+			return fmt.Errorf("%w: %s/%s", err, taskId, cluster)
+		}
+
+		if ready {
+			return dri.probeExecuteCommand(ctx, cluster, taskId, container, deadline)
+		}
+
+		if status != lastStatus {
+			fmt.Fprintf(os.Stderr, "Waiting for ExecuteCommandAgent (%s)...\n", status)
+			lastStatus = status
+		}
+
+		if time.Now().Add(interval).After(deadline) {
+			return fmt.Errorf("timed out waiting for ExecuteCommandAgent to be RUNNING (last status: %s). "+
+				"Check that the task role allows ssmmessages:CreateControlChannel, ssmmessages:CreateDataChannel, "+
+				"ssmmessages:OpenControlChannel and ssmmessages:OpenDataChannel, and that the task can reach the SSM endpoints: %s/%s",
+				lastStatus, taskId, cluster)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(interval):
+		}
+
+		interval = dri.ReadyBackoff.next(interval)
+	}
+}
+
+// probeExecuteCommand runs "id" with ECS Exec until it is accepted, and terminates the session.
+func (dri *Driver) probeExecuteCommand(ctx context.Context, cluster string, taskId string, container string, deadline time.Time) error {
+	input := &ecs.ExecuteCommandInput{
+		Cluster:     aws.String(cluster),
+		Task:        aws.String(taskId),
+		Command:     aws.String("id"),
+		Interactive: true,
+	}
+
+	if container != "" {
+		input.Container = aws.String(container)
+	}
+
+	interval := dri.ReadyBackoff.next(0)
+	var err error
+
+	for i := 0; i < readinessProbeAttempts; i++ {
+		var output *ecs.ExecuteCommandOutput
+		output, err = dri.client.ExecuteCommand(ctx, input)
+
+		if err == nil {
+			_, _ = dri.ssm.TerminateSession(ctx, &ssm.TerminateSessionInput{
+				SessionId: output.Session.SessionId,
+			})
+
+			return nil
+		}
+
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		if time.Now().Add(interval).After(deadline) {
+			break
+		}
+
+		fmt.Fprintf(os.Stderr, "Waiting for ECS Exec to accept commands...\n")
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(interval):
+		}
+
+		interval = dri.ReadyBackoff.next(interval)
+	}
+
+	return fmt.Errorf("faild to call ExecuteCommand: %s/%s: %w", taskId, cluster, err)
+}
+
+func executeCommandAgentStatus(task *types.Task, container string) (bool, string, error) {
+	if aws.ToString(task.LastStatus) == "STOPPED" {
+		return false, "", fmt.Errorf("task stopped: %s", aws.ToString(task.StoppedReason))
+	}
+
+	if !task.EnableExecuteCommand {
+		return false, "", fmt.Errorf("ECS Exec is not enabled on the task (enableExecuteCommand)")
+	}
+
+	for _, c := range task.Containers {
+		if container != "" && aws.ToString(c.Name) != container {
+			continue
+		}
+
+		for _, agent := range c.ManagedAgents {
+			if agent.Name != types.ManagedAgentNameExecuteCommandAgent {
+				continue
+			}
+
+			status := aws.ToString(agent.LastStatus)
+
+			switch status {
+			case "RUNNING":
+				return true, status, nil
+			case "STOPPED":
+				return false, status, fmt.Errorf("ExecuteCommandAgent stopped: %s", aws.ToString(agent.Reason))
+			}
+
+			return false, status, nil
+		}
+
+		// NOTE: The agent appears after the container starts
+		return false, "(none)", nil
+	}
+
+	return false, "", fmt.Errorf("container '%s' not found in task", container)
+}
@@ -21,19 +21,32 @@ const (
 type Ecspresso struct {
 	path    string
 	options string
+	checked bool
 }
 
-func NewEcspresso(path string, opts string) (*Ecspresso, error) {
-	out, err := exec.Command(path, "version").CombinedOutput()
+func NewEcspresso(path string, opts string) *Ecspresso {
+	return &Ecspresso{
+		path:    path,
+		options: opts,
+	}
+}
+
+// NOTE: Check the command only when running a task so that
+// subcommands that don't run a task work without ecspresso.
+func (ecsp *Ecspresso) check() error {
+	if ecsp.checked {
+		return nil
+	}
+
+	out, err := exec.Command(ecsp.path, "version").CombinedOutput()
 
 	if err != nil {
-		return nil, fmt.Errorf("faild to execute ecspresso: %w: %s", err, out)
+		return fmt.Errorf("faild to execute ecspresso: %w: %s", err, out)
 	}
 
-	return &Ecspresso{
-		path:    path,
-		options: opts,
-	}, nil
+	ecsp.checked = true
+
+	return nil
 }
 
 func (ecsp *Ecspresso) RunUntilRunning(def *definition.Definition, dryRun bool) (taskId string, interrupted bool, err error) {
@@ -45,6 +58,12 @@ func (ecsp *Ecspresso) RunUntilStopped(def *definition.Definition, dryRun bool)
 }
 
 func (ecsp *Ecspresso) run(def *definition.Definition, dryRun bool, untilRunning bool) (taskId string, interrupted bool, err error) {
+	err = ecsp.check()
+
+	if err != nil {
+		return
+	}
+
 	opts := ecsp.options
 
 	if untilRunning {
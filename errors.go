@@ -0,0 +1,12 @@
+package demitas2
+
+import "fmt"
+
+// ExitError makes dmts exit with the exit code of a command.
+type ExitError struct {
+	Code int
+}
+
+func (e *ExitError) Error() string {
+	return fmt.Sprintf("exit status %d", e.Code)
+}
@@ -0,0 +1,375 @@
+// Package fake provides an in-memory implementation of demitas2.TaskRunner and
+// demitas2.Driver for testing subcommands without AWS.
+package fake
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecs"
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
+	"github.com/kanmu/demitas2"
+	"github.com/kanmu/demitas2/definition"
+)
+
+var (
+	_ demitas2.TaskRunner = (*ECS)(nil)
+	_ demitas2.Driver     = (*ECS)(nil)
+)
+
+type ECS struct {
+	// Interrupt makes RunUntilRunning/RunUntilStopped behave as if SIGINT was received while waiting.
+	Interrupt bool
+	// Errors returns an error from the method of the same name (e.g. "StopTask").
+	Errors map[string]error
+	// ExitCode is set to the containers when the task stops.
+	ExitCode int32
+	// Hook is called with the call (e.g. "StopTask cluster/taskId") when a method is called.
+	Hook func(call string)
+	// Remote runs the command of ExecuteNonInteractiveCommand with the input and returns the exit code (default: ExitCode).
+	Remote func(command string, in io.Reader, out io.Writer) int
+
+	mu       sync.Mutex
+	tasks    map[string]*types.Task
+	taskDefs map[string]*types.TaskDefinition
+	calls    []string
+	nextId   int
+}
+
+func NewECS() *ECS {
+	return &ECS{
+		Errors:   map[string]error{},
+		tasks:    map[string]*types.Task{},
+		taskDefs: map[string]*types.TaskDefinition{},
+	}
+}
+
+// AddTask puts a task and its task definition in memory.
+func (fake *ECS) AddTask(task *types.Task, taskDef *types.TaskDefinition) {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	taskArn := aws.ToString(task.TaskArn)
+	fake.tasks[taskArn[strings.LastIndex(taskArn, "/")+1:]] = task
+
+	if taskDef != nil {
+		fake.taskDefs[aws.ToString(taskDef.TaskDefinitionArn)] = taskDef
+	}
+}
+
+// Calls returns the called methods with their arguments (e.g. "StopTask cluster/taskId").
+func (fake *ECS) Calls() []string {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	return append([]string{}, fake.calls...)
+}
+
+// Called returns true if the method was called with the arguments.
+func (fake *ECS) Called(call string) bool {
+	for _, c := range fake.Calls() {
+		if c == call || strings.HasPrefix(c, call+" ") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Task returns a copy of the task in memory.
+func (fake *ECS) Task(taskId string) *types.Task {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	task, ok := fake.tasks[taskId]
+
+	if !ok {
+		return nil
+	}
+
+	return copyTask(task)
+}
+
+// NOTE: Copy slices that are updated in memory
+func copyTask(task *types.Task) *types.Task {
+	c := *task
+	c.Tags = append([]types.Tag{}, task.Tags...)
+	c.Containers = append([]types.Container{}, task.Containers...)
+	return &c
+}
+
+func (fake *ECS) record(method string, args ...string) error {
+	call := method
+
+	if len(args) > 0 {
+		call += " " + strings.Join(args, "/")
+	}
+
+	fake.mu.Lock()
+	fake.calls = append(fake.calls, call)
+	err := fake.Errors[method]
+	hook := fake.Hook
+	fake.mu.Unlock()
+
+	if hook != nil {
+		hook(call)
+	}
+
+	return err
+}
+
+func (fake *ECS) RunUntilRunning(def *definition.Definition, dryRun bool) (taskId string, interrupted bool, err error) {
+	return fake.run("RunUntilRunning", def, dryRun, "RUNNING")
+}
+
+func (fake *ECS) RunUntilStopped(def *definition.Definition, dryRun bool) (taskId string, interrupted bool, err error) {
+	return fake.run("RunUntilStopped", def, dryRun, "STOPPED")
+}
+
+func (fake *ECS) run(method string, def *definition.Definition, dryRun bool, status string) (string, bool, error) {
+	err := fake.record(method, def.Cluster)
+
+	if err != nil || dryRun {
+		return "", false, err
+	}
+
+	containerName := def.MainContainer
+
+	if containerName == "" {
+		containerName = "main"
+	}
+
+	taskDef := &types.TaskDefinition{}
+	_ = json.Unmarshal(def.Task.Content, taskDef)
+	input := &ecs.RegisterTaskDefinitionInput{}
+	_ = json.Unmarshal(def.Task.Content, input)
+
+	fake.mu.Lock()
+	fake.nextId++
+	taskId := fmt.Sprintf("%032x", fake.nextId)
+	taskDef.TaskDefinitionArn = aws.String(fmt.Sprintf("arn:aws:ecs:us-east-1:123456789012:task-definition/%s:%d", aws.ToString(taskDef.Family), fake.nextId))
+	fake.taskDefs[*taskDef.TaskDefinitionArn] = taskDef
+	task := &types.Task{
+		TaskArn:           aws.String(fmt.Sprintf("arn:aws:ecs:us-east-1:123456789012:task/%s/%s", def.Cluster, taskId)),
+		ClusterArn:        aws.String("arn:aws:ecs:us-east-1:123456789012:cluster/" + def.Cluster),
+		TaskDefinitionArn: taskDef.TaskDefinitionArn,
+		LastStatus:        aws.String(status),
+		DesiredStatus:     aws.String(status),
+		StartedAt:         aws.Time(time.Now()),
+		Tags:              input.Tags,
+		Containers: []types.Container{
+			{
+				Name:       aws.String(containerName),
+				RuntimeId:  aws.String(taskId + "-0"),
+				LastStatus: aws.String(status),
+			},
+		},
+	}
+
+	if status == "STOPPED" {
+		task.StoppedReason = aws.String("Essential container in task exited")
+		task.Containers[0].ExitCode = aws.Int32(fake.ExitCode)
+	}
+
+	fake.tasks[taskId] = task
+	fake.mu.Unlock()
+
+	if fake.Interrupt {
+		return taskId, true, fake.StopTask(def.Cluster, taskId)
+	}
+
+	return taskId, false, nil
+}
+
+func (fake *ECS) StopTask(cluster string, taskId string) error {
+	err := fake.record("StopTask", cluster, taskId)
+
+	if err != nil {
+		return err
+	}
+
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	task, ok := fake.tasks[taskId]
+
+	if !ok {
+		return fmt.Errorf("task not found: %s/%s", taskId, cluster)
+	}
+
+	task.LastStatus = aws.String("STOPPED")
+	task.DesiredStatus = aws.String("STOPPED")
+	task.StoppedReason = aws.String("Task stopped by user")
+
+	for i := range task.Containers {
+		task.Containers[i].LastStatus = aws.String("STOPPED")
+	}
+
+	return nil
+}
+
+func (fake *ECS) DescribeTask(cluster string, taskId string) (*types.Task, error) {
+	err := fake.record("DescribeTask", cluster, taskId)
+
+	if err != nil {
+		return nil, err
+	}
+
+	task := fake.Task(taskId)
+
+	if task == nil {
+		return nil, fmt.Errorf("task not found: %s/%s", taskId, cluster)
+	}
+
+	return task, nil
+}
+
+func (fake *ECS) TagTask(cluster string, taskId string, tags map[string]string) error {
+	err := fake.record("TagTask", cluster, taskId)
+
+	if err != nil {
+		return err
+	}
+
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	task, ok := fake.tasks[taskId]
+
+	if !ok {
+		return fmt.Errorf("task not found: %s/%s", taskId, cluster)
+	}
+
+	for k, v := range tags {
+		found := false
+
+		for i, t := range task.Tags {
+			if aws.ToString(t.Key) == k {
+				task.Tags[i].Value = aws.String(v)
+				found = true
+			}
+		}
+
+		if !found {
+			task.Tags = append(task.Tags, types.Tag{Key: aws.String(k), Value: aws.String(v)})
+		}
+	}
+
+	return nil
+}
+
+func (fake *ECS) ListTasks(cluster string) ([]types.Task, error) {
+	err := fake.record("ListTasks", cluster)
+
+	if err != nil {
+		return nil, err
+	}
+
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	tasks := []types.Task{}
+
+	for _, task := range fake.tasks {
+		if strings.HasSuffix(aws.ToString(task.ClusterArn), "/"+cluster) && aws.ToString(task.DesiredStatus) == "RUNNING" {
+			tasks = append(tasks, *copyTask(task))
+		}
+	}
+
+	return tasks, nil
+}
+
+func (fake *ECS) DescribeTaskDefinition(taskDefArn string) (*types.TaskDefinition, error) {
+	err := fake.record("DescribeTaskDefinition", taskDefArn)
+
+	if err != nil {
+		return nil, err
+	}
+
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	taskDef, ok := fake.taskDefs[taskDefArn]
+
+	if !ok {
+		return nil, fmt.Errorf("task definition not found: %s", taskDefArn)
+	}
+
+	return taskDef, nil
+}
+
+func (fake *ECS) GetContainerId(cluster string, taskId string, container string) (string, error) {
+	task, err := fake.DescribeTask(cluster, taskId)
+
+	if err != nil {
+		return "", err
+	}
+
+	for _, c := range task.Containers {
+		if container == "" || *c.Name == container {
+			return *c.RuntimeId, nil
+		}
+	}
+
+	return "", fmt.Errorf("container '%s' not found in task: %s/%s", container, taskId, cluster)
+}
+
+func (fake *ECS) WaitForExecuteCommandAgent(ctx context.Context, cluster string, taskId string, container string, timeout time.Duration) error {
+	return fake.record("WaitForExecuteCommandAgent", cluster, taskId, container)
+}
+
+func (fake *ECS) ExecuteInteractiveCommand(cluster string, taskId string, container string, command string) error {
+	return fake.record("ExecuteInteractiveCommand", cluster, taskId, container, command)
+}
+
+// NOTE: Return ExitCode as the exit code of the command if Remote is not set
+func (fake *ECS) ExecuteNonInteractiveCommand(cluster string, taskId string, container string, command string, in io.Reader, out io.Writer) (int, error) {
+	err := fake.record("ExecuteNonInteractiveCommand", cluster, taskId, container, command)
+
+	if err != nil {
+		return 0, err
+	}
+
+	if fake.Remote != nil {
+		if in == nil {
+			in = strings.NewReader("")
+		}
+
+		return fake.Remote(command, in, out), nil
+	}
+
+	return int(fake.ExitCode), nil
+}
+
+func (fake *ECS) TailLogs(ctx context.Context, region string, group string, streamPrefix string, out io.Writer) error {
+	err := fake.record("TailLogs", region, group, streamPrefix)
+
+	if err != nil {
+		return err
+	}
+
+	<-ctx.Done()
+
+	return nil
+}
+
+func (fake *ECS) StartPortForwardingSessions(ctx context.Context, cluster string, taskId string, containerId string, forwards []demitas2.PortForward, ready chan<- struct{}) error {
+	specs := []string{}
+
+	for _, pf := range forwards {
+		specs = append(specs, pf.String())
+	}
+
+	err := fake.record("StartPortForwardingSessions", cluster, taskId, containerId, strings.Join(specs, ","))
+
+	if err != nil {
+		return err
+	}
+
+	if ready != nil {
+		close(ready)
+	}
+
+	<-ctx.Done()
+
+	return nil
+}
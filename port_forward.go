@@ -0,0 +1,59 @@
+package demitas2
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// PortForward is a port forwarding spec in "local:host:remote" format (like ssh -L).
+type PortForward struct {
+	LocalPort  uint
+	RemoteHost string
+	RemotePort uint
+}
+
+func (pf *PortForward) UnmarshalText(text []byte) error {
+	spec := string(text)
+	fields := strings.Split(spec, ":")
+
+	if len(fields) != 3 {
+		return fmt.Errorf("port forwarding must be 'local:host:remote': %s", spec)
+	}
+
+	localPort, err := parsePort(fields[0])
+
+	if err != nil {
+		return fmt.Errorf("invalid local port: %w: %s", err, spec)
+	}
+
+	remotePort, err := parsePort(fields[2])
+
+	if err != nil {
+		return fmt.Errorf("invalid remote port: %w: %s", err, spec)
+	}
+
+	if fields[1] == "" {
+		return fmt.Errorf("remote host is empty: %s", spec)
+	}
+
+	pf.LocalPort = localPort
+	pf.RemoteHost = fields[1]
+	pf.RemotePort = remotePort
+
+	return nil
+}
+
+func (pf PortForward) String() string {
+	return fmt.Sprintf("%d:%s:%d", pf.LocalPort, pf.RemoteHost, pf.RemotePort)
+}
+
+func parsePort(s string) (uint, error) {
+	port, err := strconv.ParseUint(s, 10, 16)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return uint(port), nil
+}
@@ -0,0 +1,147 @@
+package ssmsession
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"time"
+)
+
+// NOTE: See https://github.com/aws/session-manager-plugin/blob/mainline/src/message/clientmessage.go
+const (
+	messageTypeInputStreamData  = "input_stream_data"
+	messageTypeOutputStreamData = "output_stream_data"
+	messageTypeAcknowledge      = "acknowledge"
+	messageTypeChannelClosed    = "channel_closed"
+	messageTypeStartPublication = "start_publication"
+	messageTypePausePublication = "pause_publication"
+)
+
+const (
+	payloadTypeOutput               uint32 = 1
+	payloadTypeError                uint32 = 2
+	payloadTypeSize                 uint32 = 3
+	payloadTypeParameter            uint32 = 4
+	payloadTypeHandshakeRequest     uint32 = 5
+	payloadTypeHandshakeResponse    uint32 = 6
+	payloadTypeHandshakeComplete    uint32 = 7
+	payloadTypeEncChallengeRequest  uint32 = 8
+	payloadTypeEncChallengeResponse uint32 = 9
+	payloadTypeFlag                 uint32 = 10
+	payloadTypeStdErr               uint32 = 11
+	payloadTypeExitCode             uint32 = 12
+)
+
+const (
+	flagDisconnectToPort   uint32 = 1
+	flagTerminateSession   uint32 = 2
+	flagConnectToPortError uint32 = 3
+)
+
+const (
+	headerLength      = 116
+	messageTypeLength = 32
+	schemaVersion     = 1
+	// NOTE: Flags of acknowledge messages (SYN|FIN)
+	flagsAcknowledge = 3
+)
+
+type uuid [16]byte
+
+func newUUID() uuid {
+	var id uuid
+
+	if _, err := rand.Read(id[:]); err != nil {
+		panic(err)
+	}
+
+	id[6] = (id[6] & 0x0f) | 0x40
+	id[8] = (id[8] & 0x3f) | 0x80
+
+	return id
+}
+
+func (id uuid) String() string {
+	h := hex.EncodeToString(id[:])
+	return h[0:8] + "-" + h[8:12] + "-" + h[12:16] + "-" + h[16:20] + "-" + h[20:32]
+}
+
+type message struct {
+	MessageType    string
+	SchemaVersion  uint32
+	CreatedDate    uint64
+	SequenceNumber int64
+	Flags          uint64
+	MessageId      uuid
+	PayloadType    uint32
+	Payload        []byte
+}
+
+func newMessage(messageType string, sequenceNumber int64, payloadType uint32, payload []byte) *message {
+	return &message{
+		MessageType:    messageType,
+		SchemaVersion:  schemaVersion,
+		CreatedDate:    uint64(time.Now().UnixMilli()),
+		SequenceNumber: sequenceNumber,
+		MessageId:      newUUID(),
+		PayloadType:    payloadType,
+		Payload:        payload,
+	}
+}
+
+// NOTE: The least significant 8 bytes of the message ID come first
+func (msg *message) marshal() []byte {
+	buf := make([]byte, headerLength+4+len(msg.Payload))
+	binary.BigEndian.PutUint32(buf[0:4], headerLength)
+	copy(buf[4:4+messageTypeLength], bytes.Repeat([]byte(" "), messageTypeLength))
+	copy(buf[4:4+messageTypeLength], msg.MessageType)
+	binary.BigEndian.PutUint32(buf[36:40], msg.SchemaVersion)
+	binary.BigEndian.PutUint64(buf[40:48], msg.CreatedDate)
+	binary.BigEndian.PutUint64(buf[48:56], uint64(msg.SequenceNumber))
+	binary.BigEndian.PutUint64(buf[56:64], msg.Flags)
+	copy(buf[64:72], msg.MessageId[8:16])
+	copy(buf[72:80], msg.MessageId[0:8])
+	digest := sha256.Sum256(msg.Payload)
+	copy(buf[80:112], digest[:])
+	binary.BigEndian.PutUint32(buf[112:116], msg.PayloadType)
+	binary.BigEndian.PutUint32(buf[116:120], uint32(len(msg.Payload)))
+	copy(buf[120:], msg.Payload)
+
+	return buf
+}
+
+func unmarshalMessage(buf []byte) (*message, error) {
+	if len(buf) < headerLength+4 {
+		return nil, fmt.Errorf("message is too short: %d bytes", len(buf))
+	}
+
+	hl := int(binary.BigEndian.Uint32(buf[0:4]))
+
+	if hl < headerLength || len(buf) < hl+4 {
+		return nil, fmt.Errorf("invalid header length: %d", hl)
+	}
+
+	msg := &message{
+		MessageType:    string(bytes.TrimRight(buf[4:4+messageTypeLength], " \x00")),
+		SchemaVersion:  binary.BigEndian.Uint32(buf[36:40]),
+		CreatedDate:    binary.BigEndian.Uint64(buf[40:48]),
+		SequenceNumber: int64(binary.BigEndian.Uint64(buf[48:56])),
+		Flags:          binary.BigEndian.Uint64(buf[56:64]),
+		PayloadType:    binary.BigEndian.Uint32(buf[112:116]),
+	}
+
+	copy(msg.MessageId[8:16], buf[64:72])
+	copy(msg.MessageId[0:8], buf[72:80])
+	payloadLength := int(binary.BigEndian.Uint32(buf[hl : hl+4]))
+
+	if len(buf) < hl+4+payloadLength {
+		return nil, fmt.Errorf("invalid payload length: %d", payloadLength)
+	}
+
+	msg.Payload = buf[hl+4 : hl+4+payloadLength]
+
+	return msg, nil
+}
@@ -0,0 +1,112 @@
+package ssmsession
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+)
+
+// StartSessionOutput is the output of ssm:StartSession.
+type StartSessionOutput struct {
+	SessionId  string
+	StreamUrl  string
+	TokenValue string
+}
+
+// Starter starts and terminates SSM sessions for a target.
+type Starter interface {
+	StartSession(ctx context.Context) (*StartSessionOutput, error)
+	TerminateSession(ctx context.Context, sessionId string) error
+}
+
+// ForwardPort accepts connections on the listener and forwards each of them through a new session
+// until the context is canceled.
+func ForwardPort(ctx context.Context, listener net.Listener, starter Starter) error {
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+	}()
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	for {
+		conn, err := listener.Accept()
+
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+
+			return fmt.Errorf("failed to accept connection: %w", err)
+		}
+
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+			err := forwardConn(ctx, conn, starter)
+
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to forward connection from %s: %s\n", conn.RemoteAddr(), err)
+			}
+		}()
+	}
+}
+
+func forwardConn(ctx context.Context, conn net.Conn, starter Starter) error {
+	defer conn.Close()
+
+	out, err := starter.StartSession(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		// NOTE: Terminate the session even if the context is canceled
+		_ = starter.TerminateSession(context.Background(), out.SessionId)
+	}()
+
+	sess, err := Open(ctx, out.StreamUrl, out.TokenValue)
+
+	if err != nil {
+		return err
+	}
+
+	defer sess.Close()
+
+	remoteDone := make(chan error, 1)
+
+	go func() {
+		_, err := io.Copy(conn, sess)
+		remoteDone <- err
+	}()
+
+	localDone := make(chan error, 1)
+
+	go func() {
+		_, err := io.Copy(sess, conn)
+		localDone <- err
+	}()
+
+	select {
+	case err = <-remoteDone:
+	case err = <-localDone:
+		if err == nil {
+			_ = sess.Disconnect()
+		}
+	case <-ctx.Done():
+		_ = sess.Disconnect()
+	}
+
+	if errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) || errors.Is(err, io.ErrClosedPipe) {
+		err = nil
+	}
+
+	return err
+}
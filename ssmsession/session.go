@@ -0,0 +1,691 @@
+// Package ssmsession implements the data channel of AWS Systems Manager Session Manager,
+// which is implemented by session-manager-plugin.
+package ssmsession
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"go.uber.org/atomic"
+)
+
+const (
+	// NOTE: Clients older than 1.1.70 don't use multiplexing for port forwarding
+	clientVersion    = "1.0.0.0"
+	streamDataLength = 1024
+	handshakeTimeout = 30 * time.Second
+	pingInterval     = 5 * time.Minute
+)
+
+// NOTE: Same as session-manager-plugin
+var (
+	// Maximum number of received messages waiting to be read
+	incomingBufferCapacity = 10000
+	// Maximum number of sent messages waiting to be acknowledged
+	outgoingBufferCapacity = 10000
+	// Sent messages are resent if they are not acknowledged within the timeout
+	resendTimeout     = time.Second
+	resendInterval    = 100 * time.Millisecond
+	maxResendAttempts = 300
+)
+
+const (
+	actionStatusSuccess     = 1
+	actionStatusUnsupported = 3
+)
+
+type openDataChannelInput struct {
+	MessageSchemaVersion string
+	RequestId            string
+	TokenValue           string
+	ClientId             string
+	ClientVersion        string
+}
+
+type handshakeRequest struct {
+	AgentVersion           string
+	RequestedClientActions []struct {
+		ActionType       string
+		ActionParameters json.RawMessage
+	}
+}
+
+type processedClientAction struct {
+	ActionType   string
+	ActionStatus int
+	ActionResult json.RawMessage
+	Error        string
+}
+
+type handshakeResponse struct {
+	ClientVersion          string
+	ProcessedClientActions []processedClientAction
+	Errors                 []string
+}
+
+type acknowledgeContent struct {
+	AcknowledgedMessageType           string
+	AcknowledgedMessageId             string
+	AcknowledgedMessageSequenceNumber int64
+	IsSequentialMessage               bool
+}
+
+type channelClosed struct {
+	SessionId string
+	Output    string
+}
+
+// Session is a data channel of an SSM session.
+// Read returns the output of the session and Write sends the input to the session.
+type Session struct {
+	ws      *websocket.Conn
+	writeMu sync.Mutex
+	seq     int64
+
+	// NOTE: Flow control of sent messages (pause_publication and acknowledge)
+	flowMu   sync.Mutex
+	flowCond *sync.Cond
+	pausing  bool
+	unacked  map[int64]*sentMessage
+
+	// NOTE: Received messages are buffered so that the read loop does not block on the reader
+	expected int64
+	pending  map[int64]*message
+	output   chan []byte
+	buffered atomic.Int64
+	outR     *io.PipeReader
+	outW     *io.PipeWriter
+
+	handshakeDone chan struct{}
+	handshakeOnce sync.Once
+
+	readDone chan struct{}
+	readErr  error
+	exitCode *int
+
+	done      chan struct{}
+	closeOnce sync.Once
+	err       error
+}
+
+type sentMessage struct {
+	data     []byte
+	sentAt   time.Time
+	attempts int
+}
+
+// Open connects to the stream URL returned by StartSession (or ExecuteCommand) and waits for the handshake.
+func Open(ctx context.Context, streamUrl string, token string) (*Session, error) {
+	ws, _, err := websocket.DefaultDialer.DialContext(ctx, streamUrl, nil)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to session: %w", err)
+	}
+
+	outR, outW := io.Pipe()
+	sess := &Session{
+		ws:            ws,
+		unacked:       map[int64]*sentMessage{},
+		pending:       map[int64]*message{},
+		output:        make(chan []byte, incomingBufferCapacity),
+		outR:          outR,
+		outW:          outW,
+		handshakeDone: make(chan struct{}),
+		readDone:      make(chan struct{}),
+		done:          make(chan struct{}),
+	}
+
+	sess.flowCond = sync.NewCond(&sess.flowMu)
+
+	input := &openDataChannelInput{
+		MessageSchemaVersion: "1.0",
+		RequestId:            newUUID().String(),
+		TokenValue:           token,
+		ClientId:             newUUID().String(),
+		ClientVersion:        clientVersion,
+	}
+
+	js, err := json.Marshal(input)
+
+	if err != nil {
+		panic(err)
+	}
+
+	sess.writeMu.Lock()
+	err = ws.WriteMessage(websocket.TextMessage, js)
+	sess.writeMu.Unlock()
+
+	if err != nil {
+		ws.Close()
+		return nil, fmt.Errorf("failed to open data channel: %w", err)
+	}
+
+	go sess.readLoop()
+	go sess.writeLoop()
+	go sess.resendLoop(resendTimeout, resendInterval, maxResendAttempts)
+	go sess.pingLoop()
+
+	timer := time.NewTimer(handshakeTimeout)
+	defer timer.Stop()
+
+	select {
+	case <-sess.handshakeDone:
+		return sess, nil
+	case <-sess.done:
+		return nil, fmt.Errorf("session closed during handshake: %w", sess.err)
+	case <-timer.C:
+		sess.Close()
+		return nil, fmt.Errorf("session handshake timed out")
+	case <-ctx.Done():
+		sess.Close()
+		return nil, ctx.Err()
+	}
+}
+
+func (sess *Session) Read(p []byte) (int, error) {
+	return sess.outR.Read(p)
+}
+
+func (sess *Session) Write(p []byte) (int, error) {
+	n := 0
+
+	for len(p) > 0 {
+		chunk := p[:min(len(p), streamDataLength)]
+		err := sess.sendInput(payloadTypeOutput, chunk)
+
+		if err != nil {
+			return n, err
+		}
+
+		n += len(chunk)
+		p = p[len(chunk):]
+	}
+
+	return n, nil
+}
+
+// SetSize sends the terminal size to the session.
+func (sess *Session) SetSize(cols int, rows int) error {
+	js, err := json.Marshal(map[string]int{"cols": cols, "rows": rows})
+
+	if err != nil {
+		panic(err)
+	}
+
+	return sess.sendInput(payloadTypeSize, js)
+}
+
+// Done is closed when the session is closed.
+func (sess *Session) Done() <-chan struct{} {
+	return sess.done
+}
+
+// Err returns the reason why the session was closed.
+func (sess *Session) Err() error {
+	select {
+	case <-sess.done:
+		return sess.err
+	default:
+		return nil
+	}
+}
+
+// ExitCode returns the exit code sent by the agent, if any.
+func (sess *Session) ExitCode() (int, bool) {
+	select {
+	case <-sess.readDone:
+	default:
+		return 0, false
+	}
+
+	if sess.exitCode == nil {
+		return 0, false
+	}
+
+	return *sess.exitCode, true
+}
+
+func (sess *Session) Close() error {
+	sess.closeWithError(nil)
+	return nil
+}
+
+func (sess *Session) closeWithError(err error) {
+	sess.closeOnce.Do(func() {
+		sess.err = err
+
+		if err != nil {
+			sess.outW.CloseWithError(err)
+		} else {
+			sess.outW.Close()
+		}
+
+		sess.flowMu.Lock()
+		sess.flowCond.Broadcast()
+		sess.flowMu.Unlock()
+
+		sess.writeMu.Lock()
+		_ = sess.ws.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
+		sess.writeMu.Unlock()
+		sess.ws.Close()
+		close(sess.done)
+	})
+}
+
+func (sess *Session) sendInput(payloadType uint32, payload []byte) error {
+	select {
+	case <-sess.handshakeDone:
+	case <-sess.done:
+		return sess.closedError()
+	}
+
+	sess.flowMu.Lock()
+
+	for (sess.pausing || len(sess.unacked) >= outgoingBufferCapacity) && !sess.closed() {
+		sess.flowCond.Wait()
+	}
+
+	sess.flowMu.Unlock()
+
+	sess.writeMu.Lock()
+	defer sess.writeMu.Unlock()
+
+	if sess.closed() {
+		return sess.closedError()
+	}
+
+	err := sess.sendSequential(newMessage(messageTypeInputStreamData, sess.seq, payloadType, payload))
+
+	if err != nil {
+		return fmt.Errorf("failed to send input: %w", err)
+	}
+
+	return nil
+}
+
+// sendSequential sends the message and keeps it until it is acknowledged.
+// NOTE: writeMu must be held
+func (sess *Session) sendSequential(msg *message) error {
+	data := msg.marshal()
+
+	// NOTE: Keep the message before sending it because the acknowledge may arrive first
+	sess.flowMu.Lock()
+	sess.unacked[msg.SequenceNumber] = &sentMessage{data: data, sentAt: time.Now()}
+	sess.flowMu.Unlock()
+
+	err := sess.ws.WriteMessage(websocket.BinaryMessage, data)
+
+	if err != nil {
+		sess.flowMu.Lock()
+		delete(sess.unacked, msg.SequenceNumber)
+		sess.flowMu.Unlock()
+		return err
+	}
+
+	sess.seq++
+
+	return nil
+}
+
+func (sess *Session) sendMessage(msg *message) error {
+	sess.writeMu.Lock()
+	defer sess.writeMu.Unlock()
+	return sess.ws.WriteMessage(websocket.BinaryMessage, msg.marshal())
+}
+
+func (sess *Session) closed() bool {
+	select {
+	case <-sess.done:
+		return true
+	default:
+		return false
+	}
+}
+
+func (sess *Session) closedError() error {
+	if sess.err != nil {
+		return sess.err
+	}
+
+	return io.ErrClosedPipe
+}
+
+func (sess *Session) pingLoop() {
+	ticker := time.NewTicker(pingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			_ = sess.ws.WriteControl(websocket.PingMessage, []byte("keepalive"), time.Now().Add(10*time.Second))
+		case <-sess.done:
+			return
+		}
+	}
+}
+
+// resendLoop resends the messages that are not acknowledged in time.
+func (sess *Session) resendLoop(timeout time.Duration, interval time.Duration, maxAttempts int) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-sess.done:
+			return
+		}
+
+		sess.flowMu.Lock()
+		seqs := []int64{}
+
+		for seq, sent := range sess.unacked {
+			if time.Since(sent.sentAt) >= timeout {
+				seqs = append(seqs, seq)
+			}
+		}
+
+		slices.Sort(seqs)
+		resent := [][]byte{}
+		var err error
+
+		for _, seq := range seqs {
+			sent := sess.unacked[seq]
+			sent.attempts++
+
+			if sent.attempts > maxAttempts {
+				err = fmt.Errorf("message is not acknowledged: sequence number %d", seq)
+				break
+			}
+
+			sent.sentAt = time.Now()
+			resent = append(resent, sent.data)
+		}
+
+		sess.flowMu.Unlock()
+
+		if err != nil {
+			sess.closeWithError(err)
+			return
+		}
+
+		sess.writeMu.Lock()
+
+		for _, data := range resent {
+			err = sess.ws.WriteMessage(websocket.BinaryMessage, data)
+
+			if err != nil {
+				break
+			}
+		}
+
+		sess.writeMu.Unlock()
+
+		// NOTE: The read loop closes the session if the connection is lost
+		if err != nil {
+			return
+		}
+	}
+}
+
+// writeLoop writes the buffered output to the reader.
+func (sess *Session) writeLoop() {
+	for payload := range sess.output {
+		_, err := sess.outW.Write(payload)
+		sess.buffered.Dec()
+
+		// NOTE: The reader is closed
+		if err != nil {
+			sess.closeWithError(io.EOF)
+			return
+		}
+	}
+
+	sess.closeWithError(sess.readErr)
+}
+
+func (sess *Session) readLoop() {
+	err := sess.read()
+
+	if websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+		err = nil
+	}
+
+	// NOTE: The output is closed after the buffered output is written
+	sess.readErr = err
+	close(sess.readDone)
+	close(sess.output)
+}
+
+func (sess *Session) read() error {
+	for {
+		typ, data, err := sess.ws.ReadMessage()
+
+		if err != nil {
+			return err
+		}
+
+		if typ != websocket.BinaryMessage {
+			continue
+		}
+
+		msg, err := unmarshalMessage(data)
+
+		if err != nil {
+			return fmt.Errorf("failed to parse message: %w", err)
+		}
+
+		err = sess.handleMessage(msg)
+
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (sess *Session) handleMessage(msg *message) error {
+	switch msg.MessageType {
+	case messageTypeOutputStreamData:
+		return sess.handleOutputStreamData(msg)
+	case messageTypeAcknowledge:
+		return sess.handleAcknowledge(msg)
+	case messageTypeChannelClosed:
+		closed := &channelClosed{}
+		_ = json.Unmarshal(msg.Payload, closed)
+
+		if closed.Output != "" {
+			return fmt.Errorf("session closed: %s", closed.Output)
+		}
+
+		return io.EOF
+	case messageTypePausePublication:
+		sess.setPausing(true)
+	case messageTypeStartPublication:
+		sess.setPausing(false)
+	}
+
+	return nil
+}
+
+// NOTE: Messages are acknowledged only if they are buffered, so that the agent resends dropped messages
+func (sess *Session) handleOutputStreamData(msg *message) error {
+	// NOTE: Drop resent messages
+	if _, ok := sess.pending[msg.SequenceNumber]; ok || msg.SequenceNumber < sess.expected {
+		return sess.acknowledge(msg)
+	}
+
+	if int(sess.buffered.Load())+len(sess.pending) >= cap(sess.output) {
+		return nil
+	}
+
+	err := sess.acknowledge(msg)
+
+	if err != nil {
+		return err
+	}
+
+	if msg.SequenceNumber > sess.expected {
+		sess.pending[msg.SequenceNumber] = msg
+		return nil
+	}
+
+	// NOTE: Process messages in sequence
+	for {
+		err = sess.handleStreamData(msg)
+
+		if err != nil {
+			return err
+		}
+
+		sess.expected++
+		next, ok := sess.pending[sess.expected]
+
+		if !ok {
+			return nil
+		}
+
+		delete(sess.pending, sess.expected)
+		msg = next
+	}
+}
+
+func (sess *Session) handleAcknowledge(msg *message) error {
+	ack := &acknowledgeContent{}
+	err := json.Unmarshal(msg.Payload, ack)
+
+	if err != nil {
+		return fmt.Errorf("failed to parse acknowledge: %w", err)
+	}
+
+	sess.flowMu.Lock()
+	defer sess.flowMu.Unlock()
+	delete(sess.unacked, ack.AcknowledgedMessageSequenceNumber)
+	sess.flowCond.Broadcast()
+
+	return nil
+}
+
+func (sess *Session) setPausing(pausing bool) {
+	sess.flowMu.Lock()
+	defer sess.flowMu.Unlock()
+	sess.pausing = pausing
+	sess.flowCond.Broadcast()
+}
+
+func (sess *Session) acknowledge(msg *message) error {
+	ack := &acknowledgeContent{
+		AcknowledgedMessageType:           msg.MessageType,
+		AcknowledgedMessageId:             msg.MessageId.String(),
+		AcknowledgedMessageSequenceNumber: msg.SequenceNumber,
+		IsSequentialMessage:               true,
+	}
+
+	js, err := json.Marshal(ack)
+
+	if err != nil {
+		panic(err)
+	}
+
+	ackMsg := newMessage(messageTypeAcknowledge, 0, 0, js)
+	ackMsg.Flags = flagsAcknowledge
+
+	err = sess.sendMessage(ackMsg)
+
+	if err != nil {
+		return fmt.Errorf("failed to send acknowledge: %w", err)
+	}
+
+	return nil
+}
+
+func (sess *Session) handleStreamData(msg *message) error {
+	switch msg.PayloadType {
+	case payloadTypeOutput, payloadTypeStdErr:
+		// NOTE: Never blocks because the buffered messages are less than the capacity
+		sess.buffered.Inc()
+		sess.output <- msg.Payload
+	case payloadTypeHandshakeRequest:
+		return sess.handleHandshakeRequest(msg.Payload)
+	case payloadTypeHandshakeComplete:
+		sess.handshakeOnce.Do(func() { close(sess.handshakeDone) })
+	case payloadTypeFlag:
+		if len(msg.Payload) >= 4 && binary.BigEndian.Uint32(msg.Payload) == flagConnectToPortError {
+			return errors.New("failed to connect to the remote host")
+		}
+	case payloadTypeExitCode:
+		var exitCode int
+
+		if _, err := fmt.Sscanf(string(msg.Payload), "%d", &exitCode); err == nil {
+			sess.exitCode = &exitCode
+		}
+	case payloadTypeEncChallengeRequest:
+		return errors.New("KMS encryption of sessions is not supported")
+	}
+
+	return nil
+}
+
+func (sess *Session) handleHandshakeRequest(payload []byte) error {
+	req := &handshakeRequest{}
+	err := json.Unmarshal(payload, req)
+
+	if err != nil {
+		return fmt.Errorf("failed to parse handshake request: %w", err)
+	}
+
+	res := &handshakeResponse{
+		ClientVersion:          clientVersion,
+		ProcessedClientActions: []processedClientAction{},
+		Errors:                 []string{},
+	}
+
+	for _, action := range req.RequestedClientActions {
+		processed := processedClientAction{ActionType: action.ActionType}
+
+		switch action.ActionType {
+		case "SessionType":
+			processed.ActionStatus = actionStatusSuccess
+		default:
+			processed.ActionStatus = actionStatusUnsupported
+			processed.Error = fmt.Sprintf("unsupported action: %s", action.ActionType)
+			res.Errors = append(res.Errors, processed.Error)
+		}
+
+		res.ProcessedClientActions = append(res.ProcessedClientActions, processed)
+	}
+
+	js, err := json.Marshal(res)
+
+	if err != nil {
+		panic(err)
+	}
+
+	sess.writeMu.Lock()
+	defer sess.writeMu.Unlock()
+	err = sess.sendSequential(newMessage(messageTypeInputStreamData, sess.seq, payloadTypeHandshakeResponse, js))
+
+	if err != nil {
+		return fmt.Errorf("failed to send handshake response: %w", err)
+	}
+
+	if len(res.Errors) > 0 {
+		return fmt.Errorf("handshake failed: %v", res.Errors)
+	}
+
+	return nil
+}
+
+// Disconnect notifies the agent that the local connection of port forwarding is closed.
+func (sess *Session) Disconnect() error {
+	payload := make([]byte, 4)
+	binary.BigEndian.PutUint32(payload, flagDisconnectToPort)
+	return sess.sendInput(payloadTypeFlag, payload)
+}
@@ -0,0 +1,433 @@
+package ssmsession
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const testToken = "test-token"
+
+// fakeAgent is the agent side of a data channel.
+type fakeAgent struct {
+	t  *testing.T
+	ws *websocket.Conn
+}
+
+// NOTE: Return the stream URL and the agents of the connected data channels
+func newFakeEndpoint(t *testing.T) (string, <-chan *fakeAgent) {
+	t.Helper()
+	agents := make(chan *fakeAgent, 10)
+	upgrader := websocket.Upgrader{}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := upgrader.Upgrade(w, r, nil)
+
+		if err != nil {
+			t.Errorf("failed to upgrade: %s", err)
+			return
+		}
+
+		typ, data, err := ws.ReadMessage()
+
+		if err != nil {
+			t.Errorf("failed to read open data channel input: %s", err)
+			return
+		}
+
+		input := &openDataChannelInput{}
+		err = json.Unmarshal(data, input)
+
+		if typ != websocket.TextMessage || err != nil || input.TokenValue != testToken || input.ClientVersion != clientVersion {
+			t.Errorf("invalid open data channel input: %s", data)
+			return
+		}
+
+		agents <- &fakeAgent{t: t, ws: ws}
+	}))
+
+	t.Cleanup(srv.Close)
+
+	return "ws" + strings.TrimPrefix(srv.URL, "http"), agents
+}
+
+func waitAgent(t *testing.T, agents <-chan *fakeAgent) *fakeAgent {
+	t.Helper()
+
+	select {
+	case agent := <-agents:
+		t.Cleanup(func() { agent.ws.Close() })
+		return agent
+	case <-time.After(5 * time.Second):
+		t.Fatal("data channel is not opened")
+		return nil
+	}
+}
+
+func (agent *fakeAgent) send(msg *message) {
+	agent.t.Helper()
+	err := agent.ws.WriteMessage(websocket.BinaryMessage, msg.marshal())
+
+	if err != nil {
+		agent.t.Fatalf("failed to send message: %s", err)
+	}
+}
+
+func (agent *fakeAgent) sendOutput(seq int64, payloadType uint32, payload string) {
+	agent.t.Helper()
+	agent.send(newMessage(messageTypeOutputStreamData, seq, payloadType, []byte(payload)))
+}
+
+func (agent *fakeAgent) recv() *message {
+	agent.t.Helper()
+	_ = agent.ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, data, err := agent.ws.ReadMessage()
+
+	if err != nil {
+		agent.t.Fatalf("failed to receive message: %s", err)
+	}
+
+	msg, err := unmarshalMessage(data)
+
+	if err != nil {
+		agent.t.Fatalf("failed to parse message: %s", err)
+	}
+
+	return msg
+}
+
+func (agent *fakeAgent) recvInput(payloadType uint32) *message {
+	agent.t.Helper()
+	msg := agent.recv()
+
+	if msg.MessageType != messageTypeInputStreamData || msg.PayloadType != payloadType {
+		agent.t.Fatalf("unexpected message: %s (payload type: %d)", msg.MessageType, msg.PayloadType)
+	}
+
+	return msg
+}
+
+func (agent *fakeAgent) recvAck(seq int64) {
+	agent.t.Helper()
+	msg := agent.recv()
+	ack := &acknowledgeContent{}
+
+	if msg.MessageType != messageTypeAcknowledge || json.Unmarshal(msg.Payload, ack) != nil {
+		agent.t.Fatalf("unexpected message: %s: %s", msg.MessageType, msg.Payload)
+	}
+
+	if ack.AcknowledgedMessageSequenceNumber != seq {
+		agent.t.Fatalf("unexpected acknowledge: %d (expected: %d)", ack.AcknowledgedMessageSequenceNumber, seq)
+	}
+}
+
+func (agent *fakeAgent) ack(msg *message) {
+	agent.t.Helper()
+	js, _ := json.Marshal(&acknowledgeContent{
+		AcknowledgedMessageType:           msg.MessageType,
+		AcknowledgedMessageId:             msg.MessageId.String(),
+		AcknowledgedMessageSequenceNumber: msg.SequenceNumber,
+		IsSequentialMessage:               true,
+	})
+
+	agent.send(newMessage(messageTypeAcknowledge, 0, 0, js))
+}
+
+// NOTE: The handshake uses the sequence numbers 0 and 1 of both sides
+func (agent *fakeAgent) handshake() {
+	agent.t.Helper()
+	agent.sendOutput(0, payloadTypeHandshakeRequest, `{"AgentVersion":"3.3.0.0","RequestedClientActions":[{"ActionType":"SessionType","ActionParameters":{"SessionType":"Port"}}]}`)
+	agent.recvAck(0)
+
+	msg := agent.recvInput(payloadTypeHandshakeResponse)
+	res := &handshakeResponse{}
+	err := json.Unmarshal(msg.Payload, res)
+
+	if err != nil || len(res.ProcessedClientActions) != 1 || res.ProcessedClientActions[0].ActionStatus != actionStatusSuccess {
+		agent.t.Fatalf("invalid handshake response: %s", msg.Payload)
+	}
+
+	agent.ack(msg)
+	agent.sendOutput(1, payloadTypeHandshakeComplete, `{}`)
+	agent.recvAck(1)
+}
+
+func (agent *fakeAgent) closeChannel() {
+	agent.t.Helper()
+	agent.send(newMessage(messageTypeChannelClosed, 0, 0, []byte(`{"SessionId":"session-1","Output":""}`)))
+}
+
+func openSession(t *testing.T, url string, agents <-chan *fakeAgent) (*Session, *fakeAgent) {
+	t.Helper()
+	type result struct {
+		sess *Session
+		err  error
+	}
+
+	opened := make(chan result, 1)
+
+	go func() {
+		sess, err := Open(context.Background(), url, testToken)
+		opened <- result{sess, err}
+	}()
+
+	agent := waitAgent(t, agents)
+	agent.handshake()
+	r := <-opened
+
+	if r.err != nil {
+		t.Fatal(r.err)
+	}
+
+	t.Cleanup(func() { r.sess.Close() })
+
+	return r.sess, agent
+}
+
+func setVar[T any](t *testing.T, v *T, value T) {
+	orig := *v
+	*v = value
+	t.Cleanup(func() { *v = orig })
+}
+
+func waitAcknowledged(t *testing.T, sess *Session) {
+	t.Helper()
+
+	for i := 0; i < 100; i++ {
+		sess.flowMu.Lock()
+		n := len(sess.unacked)
+		sess.flowMu.Unlock()
+
+		if n == 0 {
+			return
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	t.Fatal("sent messages are not acknowledged")
+}
+
+func TestSession(t *testing.T) {
+	setVar(t, &resendTimeout, 50*time.Millisecond)
+	setVar(t, &resendInterval, 10*time.Millisecond)
+	url, agents := newFakeEndpoint(t)
+	sess, agent := openSession(t, url, agents)
+
+	// Input is resent until it is acknowledged
+	_, err := sess.Write([]byte("ping"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	input := agent.recvInput(payloadTypeOutput)
+	resent := agent.recvInput(payloadTypeOutput)
+
+	if string(input.Payload) != "ping" || input.SequenceNumber != 1 {
+		t.Fatalf("unexpected input: %q (sequence number: %d)", input.Payload, input.SequenceNumber)
+	}
+
+	if resent.MessageId != input.MessageId || string(resent.Payload) != "ping" {
+		t.Fatalf("unexpected resent input: %q", resent.Payload)
+	}
+
+	agent.ack(resent)
+	waitAcknowledged(t, sess)
+
+	// Output is acknowledged and read in sequence
+	agent.sendOutput(2, payloadTypeOutput, "hello ")
+	agent.sendOutput(4, payloadTypeOutput, "world")
+	agent.sendOutput(3, payloadTypeOutput, "there ")
+	agent.sendOutput(2, payloadTypeOutput, "hello ")
+	agent.sendOutput(5, payloadTypeExitCode, "3")
+
+	for _, seq := range []int64{2, 4, 3, 2, 5} {
+		agent.recvAck(seq)
+	}
+
+	buf := make([]byte, len("hello there world"))
+	_, err = io.ReadFull(sess, buf)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(buf) != "hello there world" {
+		t.Errorf("unexpected output: %q", buf)
+	}
+
+	// The session ends when the channel is closed
+	agent.closeChannel()
+	rest, err := io.ReadAll(sess)
+
+	if err != nil || len(rest) != 0 {
+		t.Errorf("unexpected output after channel closed: %q: %v", rest, err)
+	}
+
+	select {
+	case <-sess.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("session is not closed")
+	}
+
+	if exitCode, ok := sess.ExitCode(); !ok || exitCode != 3 {
+		t.Errorf("unexpected exit code: %d, %v", exitCode, ok)
+	}
+}
+
+func TestSessionDropsOutputOverCapacity(t *testing.T) {
+	setVar(t, &incomingBufferCapacity, 2)
+	url, agents := newFakeEndpoint(t)
+	sess, agent := openSession(t, url, agents)
+
+	// NOTE: The output that does not fit in the buffer is not acknowledged
+	agent.sendOutput(2, payloadTypeOutput, "a")
+	agent.sendOutput(3, payloadTypeOutput, "b")
+	agent.sendOutput(4, payloadTypeOutput, "c")
+	agent.sendOutput(2, payloadTypeOutput, "a")
+	agent.recvAck(2)
+	agent.recvAck(3)
+	agent.recvAck(2)
+
+	// Acknowledges are processed while the output is not read
+	_, err := sess.Write([]byte("ping"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	agent.ack(agent.recvInput(payloadTypeOutput))
+	waitAcknowledged(t, sess)
+
+	buf := make([]byte, 2)
+	_, err = io.ReadFull(sess, buf)
+
+	if err != nil || string(buf) != "ab" {
+		t.Fatalf("unexpected output: %q: %v", buf, err)
+	}
+
+	// The dropped output is accepted when it is resent
+	agent.sendOutput(4, payloadTypeOutput, "c")
+	agent.recvAck(4)
+	_, err = io.ReadFull(sess, buf[:1])
+
+	if err != nil || string(buf[:1]) != "c" {
+		t.Fatalf("unexpected output: %q: %v", buf[:1], err)
+	}
+}
+
+type fakeStarter struct {
+	url        string
+	mu         sync.Mutex
+	started    int
+	terminated []string
+}
+
+func (starter *fakeStarter) StartSession(ctx context.Context) (*StartSessionOutput, error) {
+	starter.mu.Lock()
+	defer starter.mu.Unlock()
+	starter.started++
+
+	return &StartSessionOutput{
+		SessionId:  "session-1",
+		StreamUrl:  starter.url,
+		TokenValue: testToken,
+	}, nil
+}
+
+func (starter *fakeStarter) TerminateSession(ctx context.Context, sessionId string) error {
+	starter.mu.Lock()
+	defer starter.mu.Unlock()
+	starter.terminated = append(starter.terminated, sessionId)
+	return nil
+}
+
+func TestForwardPort(t *testing.T) {
+	url, agents := newFakeEndpoint(t)
+	starter := &fakeStarter{url: url}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	forwardErr := make(chan error, 1)
+
+	go func() {
+		forwardErr <- ForwardPort(ctx, listener, starter)
+	}()
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	agent := waitAgent(t, agents)
+	agent.handshake()
+
+	// Local to remote
+	_, err = conn.Write([]byte("request"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	input := agent.recvInput(payloadTypeOutput)
+	agent.ack(input)
+
+	if string(input.Payload) != "request" {
+		t.Errorf("unexpected input: %q", input.Payload)
+	}
+
+	// Remote to local
+	agent.sendOutput(2, payloadTypeOutput, "response")
+	agent.recvAck(2)
+	buf := make([]byte, len("response"))
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err = io.ReadFull(conn, buf)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(buf) != "response" {
+		t.Errorf("unexpected output: %q", buf)
+	}
+
+	// The agent is notified when the local connection is closed
+	conn.Close()
+	flag := agent.recvInput(payloadTypeFlag)
+	agent.ack(flag)
+
+	if binary.BigEndian.Uint32(flag.Payload) != flagDisconnectToPort {
+		t.Errorf("unexpected flag: %v", flag.Payload)
+	}
+
+	cancel()
+
+	select {
+	case err = <-forwardErr:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("forwarding is not stopped")
+	}
+
+	if starter.started != 1 || len(starter.terminated) != 1 {
+		t.Errorf("unexpected sessions: started %d, terminated %v", starter.started, starter.terminated)
+	}
+}
@@ -0,0 +1,75 @@
+package subcmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/kanmu/demitas2"
+)
+
+type AttachCmd struct {
+	Profile   string `env:"DMTS_PROFILE" short:"p" predictor:"profile" help:"Demitas profile name (e.g. prod/api)."`
+	Command   string `env:"DMTS_EXEC_COMMAND" required:"" default:"bash" help:"Command to run on a container."`
+	Container string `env:"DMTS_EXEC_CONTAINER" help:"Container name to execute a command on (default: main container)."`
+	All       bool   `help:"List tasks launched by all users."`
+	TaskId    string `arg:"" optional:"" help:"ECS task ID (select from running tasks if omitted)."`
+}
+
+func (cmd *AttachCmd) Run(ctx *demitas2.Context) error {
+	def, err := ctx.DefinitionOpts.Load(cmd.Profile, "", "", 0, 0, false)
+
+	if err != nil {
+		return err
+	}
+
+	taskId := cmd.TaskId
+
+	if taskId == "" {
+		tasks, err := listDemitasTasks(ctx, def.Cluster, cmd.All)
+
+		if err != nil {
+			return err
+		}
+
+		task, err := selectTask(tasks)
+
+		if err != nil {
+			return err
+		}
+
+		taskId = task.id()
+	}
+
+	container := cmd.Container
+
+	if container == "" {
+		container = def.MainContainer
+	}
+
+	_, err = ctx.Ecs.GetContainerId(def.Cluster, taskId, container)
+
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Attaching to task: %s\n", taskId)
+
+	return ctx.Ecs.ExecuteInteractiveCommand(def.Cluster, taskId, container, cmd.Command)
+}
+
+// attachCommand returns the dmts command line to attach to the task.
+func attachCommand(profile string, cluster string, container string, taskId string) string {
+	args := []string{"dmts", "attach"}
+
+	if profile != "" {
+		args = append(args, "-p", profile)
+	}
+
+	args = append(args, "--cluster", cluster)
+
+	if container != "" {
+		args = append(args, "--container", container)
+	}
+
+	return strings.Join(append(args, taskId), " ")
+}
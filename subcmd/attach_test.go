@@ -0,0 +1,65 @@
+package subcmd_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
+	"github.com/kanmu/demitas2/fake"
+	"github.com/kanmu/demitas2/subcmd"
+)
+
+func addRunningTask(ecs *fake.ECS, taskId string, family string) {
+	ecs.AddTask(&types.Task{
+		TaskArn:           aws.String("arn:aws:ecs:ap-northeast-1:123456789012:task/my-cluster/" + taskId),
+		ClusterArn:        aws.String("arn:aws:ecs:ap-northeast-1:123456789012:cluster/my-cluster"),
+		TaskDefinitionArn: aws.String("arn:aws:ecs:ap-northeast-1:123456789012:task-definition/" + family + ":1"),
+		LastStatus:        aws.String("RUNNING"),
+		StartedAt:         aws.Time(time.Now().Add(-time.Hour)),
+		DesiredStatus:     aws.String("RUNNING"),
+		Containers: []types.Container{
+			{Name: aws.String("app"), RuntimeId: aws.String(taskId + "-app")},
+		},
+	}, nil)
+}
+
+func TestAttachAllFindsTasksWithDemitasEnv(t *testing.T) {
+	ctx, ecs := newTestContext(t)
+	ecs.AddTask(&types.Task{
+		TaskArn:           aws.String("arn:aws:ecs:ap-northeast-1:123456789012:task/my-cluster/envtask"),
+		ClusterArn:        aws.String("arn:aws:ecs:ap-northeast-1:123456789012:cluster/my-cluster"),
+		TaskDefinitionArn: aws.String("arn:aws:ecs:ap-northeast-1:123456789012:task-definition/web:1"),
+		LastStatus:        aws.String("RUNNING"),
+		DesiredStatus:     aws.String("RUNNING"),
+		Containers: []types.Container{
+			{Name: aws.String("app"), RuntimeId: aws.String("envtask-app")},
+		},
+	}, &types.TaskDefinition{
+		TaskDefinitionArn: aws.String("arn:aws:ecs:ap-northeast-1:123456789012:task-definition/web:1"),
+		ContainerDefinitions: []types.ContainerDefinition{
+			{Name: aws.String("app"), Environment: []types.KeyValuePair{{Name: aws.String("DEMITAS"), Value: aws.String("true")}}},
+		},
+	})
+	ecs.AddTask(&types.Task{
+		TaskArn:           aws.String("arn:aws:ecs:ap-northeast-1:123456789012:task/my-cluster/apitask"),
+		ClusterArn:        aws.String("arn:aws:ecs:ap-northeast-1:123456789012:cluster/my-cluster"),
+		TaskDefinitionArn: aws.String("arn:aws:ecs:ap-northeast-1:123456789012:task-definition/api:2"),
+		LastStatus:        aws.String("RUNNING"),
+		DesiredStatus:     aws.String("RUNNING"),
+	}, &types.TaskDefinition{
+		TaskDefinitionArn:    aws.String("arn:aws:ecs:ap-northeast-1:123456789012:task-definition/api:2"),
+		ContainerDefinitions: []types.ContainerDefinition{{Name: aws.String("app")}},
+	})
+
+	cmd := &subcmd.AttachCmd{Profile: "prod", Command: "bash", All: true}
+	err := cmd.Run(ctx)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !ecs.Called("ExecuteInteractiveCommand my-cluster/envtask/app/bash") {
+		t.Errorf("not attached to the task with DEMITAS=true: %v", ecs.Calls())
+	}
+}
@@ -0,0 +1,344 @@
+package subcmd
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/kanmu/demitas2"
+	"github.com/kanmu/demitas2/utils"
+)
+
+// NOTE: 768 bytes are encoded to a 1024-character line (TTY lines must be shorter than 4096 bytes)
+const cpChunkSize = 768
+
+var remotePathPattern = regexp.MustCompile(`^([0-9a-f]{32}):(.+)$`)
+
+type CpCmd struct {
+	Profile   string `env:"DMTS_PROFILE" short:"p" predictor:"profile" help:"Demitas profile name (e.g. prod/api)."`
+	Container string `env:"DMTS_EXEC_CONTAINER" help:"Container name to copy files from/to (default: main container)."`
+	Src       string `arg:"" help:"Source path (local path or TASK_ID:PATH)."`
+	Dst       string `arg:"" help:"Destination path (local path or TASK_ID:PATH)."`
+}
+
+type remoteFile struct {
+	taskId string
+	path   string
+}
+
+func parseRemotePath(p string) *remoteFile {
+	m := remotePathPattern.FindStringSubmatch(p)
+
+	if m == nil {
+		return nil
+	}
+
+	return &remoteFile{taskId: m[1], path: m[2]}
+}
+
+func (cmd *CpCmd) Run(ctx *demitas2.Context) error {
+	def, err := ctx.DefinitionOpts.Load(cmd.Profile, "", "", 0, 0, false)
+
+	if err != nil {
+		return err
+	}
+
+	src := parseRemotePath(cmd.Src)
+	dst := parseRemotePath(cmd.Dst)
+
+	if (src == nil) == (dst == nil) {
+		return fmt.Errorf("either source or destination must be TASK_ID:PATH")
+	}
+
+	container := cmd.Container
+
+	if container == "" {
+		container = def.MainContainer
+	}
+
+	remote := src
+
+	if remote == nil {
+		remote = dst
+	}
+
+	_, err = ctx.Ecs.GetContainerId(def.Cluster, remote.taskId, container)
+
+	if err != nil {
+		return err
+	}
+
+	c := &copier{ctx: ctx, cluster: def.Cluster, taskId: remote.taskId, container: container}
+
+	if dst != nil {
+		return c.upload(cmd.Src, dst.path)
+	}
+
+	return c.download(src.path, cmd.Dst)
+}
+
+type copier struct {
+	ctx       *demitas2.Context
+	cluster   string
+	taskId    string
+	container string
+}
+
+func (c *copier) exec(command string, in io.Reader, out io.Writer) error {
+	exitCode, err := c.ctx.Ecs.ExecuteNonInteractiveCommand(c.cluster, c.taskId, c.container, command, in, out)
+
+	if err != nil {
+		return err
+	}
+
+	if exitCode != 0 {
+		return fmt.Errorf("command failed with exit code %d: %s", exitCode, command)
+	}
+
+	return nil
+}
+
+// NOTE: Return the size and SHA-256 checksum of the remote file
+func (c *copier) stat(remotePath string) (int64, string, error) {
+	var buf bytes.Buffer
+	quoted := utils.ShellQuote(remotePath)
+	err := c.exec(fmt.Sprintf("wc -c < %s && sha256sum < %s", quoted, quoted), nil, &buf)
+
+	if err != nil {
+		return 0, "", fmt.Errorf("failed to stat remote file: %w: %s: %s", err, strings.TrimSpace(buf.String()), remotePath)
+	}
+
+	fields := strings.Fields(buf.String())
+
+	if len(fields) < 2 {
+		return 0, "", fmt.Errorf("unexpected output of stat: %q", buf.String())
+	}
+
+	size, err := strconv.ParseInt(fields[0], 10, 64)
+
+	if err != nil {
+		return 0, "", fmt.Errorf("unexpected output of stat: %q", buf.String())
+	}
+
+	return size, fields[1], nil
+}
+
+func (c *copier) upload(localPath string, remotePath string) error {
+	f, err := os.Open(localPath)
+
+	if err != nil {
+		return fmt.Errorf("failed to open local file: %w", err)
+	}
+
+	defer f.Close()
+
+	info, err := f.Stat()
+
+	if err != nil {
+		return fmt.Errorf("failed to stat local file: %w", err)
+	}
+
+	if info.IsDir() {
+		return fmt.Errorf("copying directories is not supported: %s", localPath)
+	}
+
+	if strings.HasSuffix(remotePath, "/") {
+		remotePath += filepath.Base(localPath)
+	}
+
+	fmt.Fprintf(os.Stderr, "Uploading %s to %s:%s\n", localPath, c.taskId, remotePath)
+	hash := sha256.New()
+	progress := newProgress(info.Size())
+	r, w := io.Pipe()
+
+	// NOTE: Send base64 lines so that the TTY does not interpret the content
+	go func() {
+		src := io.TeeReader(io.TeeReader(f, hash), progress)
+		buf := make([]byte, cpChunkSize)
+		line := make([]byte, base64.StdEncoding.EncodedLen(cpChunkSize)+1)
+
+		for {
+			n, err := io.ReadFull(src, buf)
+
+			if n > 0 {
+				base64.StdEncoding.Encode(line, buf[:n])
+				l := base64.StdEncoding.EncodedLen(n)
+				line[l] = '\n'
+
+				if _, werr := w.Write(line[:l+1]); werr != nil {
+					return
+				}
+			}
+
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				w.Close()
+				return
+			} else if err != nil {
+				w.CloseWithError(err)
+				return
+			}
+		}
+	}()
+
+	var out bytes.Buffer
+	err = c.exec(fmt.Sprintf("base64 -d > %s", utils.ShellQuote(remotePath)), r, &out)
+	r.Close()
+	progress.done()
+
+	if err != nil {
+		return fmt.Errorf("failed to upload file: %w", err)
+	}
+
+	size, sum, err := c.stat(remotePath)
+
+	if err != nil {
+		return err
+	}
+
+	localSum := hex.EncodeToString(hash.Sum(nil))
+
+	if size != info.Size() || sum != localSum {
+		return fmt.Errorf("checksum mismatch: local=%s (%d bytes), remote=%s (%d bytes)", localSum, info.Size(), sum, size)
+	}
+
+	fmt.Fprintf(os.Stderr, "Uploaded %d bytes (sha256: %s)\n", size, sum)
+
+	return nil
+}
+
+func (c *copier) download(remotePath string, localPath string) error {
+	if info, err := os.Stat(localPath); err == nil && info.IsDir() {
+		localPath = filepath.Join(localPath, path.Base(remotePath))
+	}
+
+	size, sum, err := c.stat(remotePath)
+
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "Downloading %s:%s to %s\n", c.taskId, remotePath, localPath)
+	tmpPath := localPath + ".dmts-tmp"
+	f, err := os.Create(tmpPath)
+
+	if err != nil {
+		return fmt.Errorf("failed to create local file: %w", err)
+	}
+
+	defer os.Remove(tmpPath)
+	defer f.Close()
+
+	hash := sha256.New()
+	progress := newProgress(size)
+	r, w := io.Pipe()
+	decodeErr := make(chan error, 1)
+
+	go func() {
+		dec := base64.NewDecoder(base64.StdEncoding, &base64Filter{r: bufio.NewReader(r)})
+		_, err := io.Copy(io.MultiWriter(f, hash, progress), dec)
+		// NOTE: Drain the pipe so that the session is not blocked
+		_, _ = io.Copy(io.Discard, r)
+		decodeErr <- err
+	}()
+
+	err = c.exec(fmt.Sprintf("base64 < %s", utils.ShellQuote(remotePath)), nil, w)
+	w.Close()
+	derr := <-decodeErr
+	progress.done()
+
+	if err != nil {
+		return fmt.Errorf("failed to download file: %w", err)
+	}
+
+	if derr != nil {
+		return fmt.Errorf("failed to decode file: %w", derr)
+	}
+
+	localSum := hex.EncodeToString(hash.Sum(nil))
+
+	if progress.n != size || localSum != sum {
+		return fmt.Errorf("checksum mismatch: local=%s (%d bytes), remote=%s (%d bytes)", localSum, progress.n, sum, size)
+	}
+
+	err = f.Close()
+
+	if err != nil {
+		return fmt.Errorf("failed to write local file: %w", err)
+	}
+
+	err = os.Rename(tmpPath, localPath)
+
+	if err != nil {
+		return fmt.Errorf("failed to rename local file: %w", err)
+	}
+
+	fmt.Fprintf(os.Stderr, "Downloaded %d bytes (sha256: %s)\n", size, sum)
+
+	return nil
+}
+
+// base64Filter drops characters other than base64 (e.g. CR/LF of the TTY).
+type base64Filter struct {
+	r io.Reader
+}
+
+func (f *base64Filter) Read(p []byte) (int, error) {
+	for {
+		n, err := f.r.Read(p)
+		m := 0
+
+		for _, b := range p[:n] {
+			if b >= 'A' && b <= 'Z' || b >= 'a' && b <= 'z' || b >= '0' && b <= '9' || b == '+' || b == '/' || b == '=' {
+				p[m] = b
+				m++
+			}
+		}
+
+		if m > 0 || err != nil {
+			return m, err
+		}
+	}
+}
+
+// progress prints the number of transferred bytes to stderr.
+type progress struct {
+	total   int64
+	n       int64
+	percent int64
+}
+
+func newProgress(total int64) *progress {
+	return &progress{total: total, percent: -1}
+}
+
+func (p *progress) Write(b []byte) (int, error) {
+	p.n += int64(len(b))
+	percent := int64(100)
+
+	if p.total > 0 {
+		percent = p.n * 100 / p.total
+	}
+
+	if percent != p.percent {
+		p.percent = percent
+		fmt.Fprintf(os.Stderr, "\r%3d%% (%d/%d bytes)", percent, p.n, p.total)
+	}
+
+	return len(b), nil
+}
+
+func (p *progress) done() {
+	if p.percent >= 0 {
+		fmt.Fprintln(os.Stderr)
+	}
+}
@@ -0,0 +1,217 @@
+package subcmd_test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/kanmu/demitas2/subcmd"
+)
+
+const cpTaskId = "0123456789abcdef0123456789abcdef"
+
+var (
+	uploadCommandPattern   = regexp.MustCompile(`^base64 -d > '(.+)'$`)
+	statCommandPattern     = regexp.MustCompile(`^wc -c < '(.+)' && sha256sum < '(.+)'$`)
+	downloadCommandPattern = regexp.MustCompile(`^base64 < '(.+)'$`)
+)
+
+// fakeRemote runs the commands of cp on files in memory.
+// If corrupt is true, the last byte of files is lost on transfer.
+type fakeRemote struct {
+	files   map[string][]byte
+	corrupt bool
+}
+
+func (remote *fakeRemote) run(command string, in io.Reader, out io.Writer) int {
+	if m := uploadCommandPattern.FindStringSubmatch(command); m != nil {
+		input, _ := io.ReadAll(in)
+		content, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(string(input)), ""))
+
+		if err != nil {
+			fmt.Fprintf(out, "base64: invalid input\r\n")
+			return 1
+		}
+
+		remote.files[m[1]] = remote.transfer(content)
+
+		return 0
+	}
+
+	if m := statCommandPattern.FindStringSubmatch(command); m != nil {
+		content, ok := remote.files[m[1]]
+
+		if !ok {
+			fmt.Fprintf(out, "sh: %s: No such file or directory\r\n", m[1])
+			return 1
+		}
+
+		fmt.Fprintf(out, "%d\r\n%x  -\r\n", len(content), sha256.Sum256(content))
+
+		return 0
+	}
+
+	if m := downloadCommandPattern.FindStringSubmatch(command); m != nil {
+		content, ok := remote.files[m[1]]
+
+		if !ok {
+			fmt.Fprintf(out, "sh: %s: No such file or directory\r\n", m[1])
+			return 1
+		}
+
+		// NOTE: base64 wraps lines at 76 characters and the TTY converts LF to CRLF
+		encoded := base64.StdEncoding.EncodeToString(remote.transfer(content))
+
+		for len(encoded) > 76 {
+			fmt.Fprintf(out, "%s\r\n", encoded[:76])
+			encoded = encoded[76:]
+		}
+
+		fmt.Fprintf(out, "%s\r\n", encoded)
+
+		return 0
+	}
+
+	fmt.Fprintf(out, "sh: command not found: %s\r\n", command)
+
+	return 127
+}
+
+func (remote *fakeRemote) transfer(content []byte) []byte {
+	if remote.corrupt && len(content) > 0 {
+		return content[:len(content)-1]
+	}
+
+	return content
+}
+
+// NOTE: Larger than a chunk of upload and including all byte values
+func cpTestContent() []byte {
+	content := []byte{}
+
+	for i := 0; i < 3000; i++ {
+		content = append(content, byte(i*7))
+	}
+
+	return content
+}
+
+func newCpTest(t *testing.T) (*subcmd.CpCmd, *fakeRemote, func() error) {
+	t.Helper()
+	ctx, ecs := newTestContext(t)
+	addRunningTask(ecs, cpTaskId, "dmts-alice-my-app")
+	remote := &fakeRemote{files: map[string][]byte{}}
+	ecs.Remote = remote.run
+	cmd := &subcmd.CpCmd{Profile: "prod"}
+
+	run := func() error {
+		var err error
+
+		captureOutput(t, func() {
+			err = cmd.Run(ctx)
+		})
+
+		return err
+	}
+
+	return cmd, remote, run
+}
+
+func TestCpUpload(t *testing.T) {
+	cmd, remote, run := newCpTest(t)
+	localPath := filepath.Join(t.TempDir(), "dump.sql")
+	content := cpTestContent()
+	err := os.WriteFile(localPath, content, 0o644)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cmd.Src = localPath
+	cmd.Dst = cpTaskId + ":/tmp/"
+	err = run()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(remote.files["/tmp/dump.sql"], content) {
+		t.Errorf("unexpected remote file: %d bytes", len(remote.files["/tmp/dump.sql"]))
+	}
+}
+
+func TestCpDownload(t *testing.T) {
+	cmd, remote, run := newCpTest(t)
+	localDir := t.TempDir()
+	content := cpTestContent()
+	remote.files["/tmp/result.csv"] = content
+
+	cmd.Src = cpTaskId + ":/tmp/result.csv"
+	cmd.Dst = localDir
+	err := run()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	downloaded, err := os.ReadFile(filepath.Join(localDir, "result.csv"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(downloaded, content) {
+		t.Errorf("unexpected local file: %d bytes", len(downloaded))
+	}
+
+	if _, err := os.Stat(filepath.Join(localDir, "result.csv.dmts-tmp")); !os.IsNotExist(err) {
+		t.Errorf("temporary file is left: %v", err)
+	}
+}
+
+func TestCpChecksumMismatch(t *testing.T) {
+	t.Run("upload", func(t *testing.T) {
+		cmd, remote, run := newCpTest(t)
+		remote.corrupt = true
+		localPath := filepath.Join(t.TempDir(), "dump.sql")
+		err := os.WriteFile(localPath, cpTestContent(), 0o644)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		cmd.Src = localPath
+		cmd.Dst = cpTaskId + ":/tmp/dump.sql"
+		err = run()
+
+		if err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("download", func(t *testing.T) {
+		cmd, remote, run := newCpTest(t)
+		remote.corrupt = true
+		localPath := filepath.Join(t.TempDir(), "result.csv")
+		remote.files["/tmp/result.csv"] = cpTestContent()
+
+		cmd.Src = cpTaskId + ":/tmp/result.csv"
+		cmd.Dst = localPath
+		err := run()
+
+		if err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if _, err := os.Stat(localPath); !os.IsNotExist(err) {
+			t.Errorf("local file is written: %v", err)
+		}
+	})
+}
@@ -1,22 +1,29 @@
 package subcmd
 
 import (
+	"context"
 	"fmt"
+	"os"
 	"time"
 
 	"github.com/kanmu/demitas2"
-	"github.com/kanmu/demitas2/utils"
 )
 
 type ExecCmd struct {
-	Profile      string `env:"DMTS_PROFILE" short:"p" help:"Demitas profile name."`
-	Command      string `env:"DMTS_EXEC_COMMAND" required:"" default:"bash" help:"Command to run on a container."`
-	Image        string `env:"DMTS_EXEC_IMAGE" short:"i" default:"mirror.gcr.io/library/debian:stable-slim" help:"Container image."`
-	Tag          string `help:"Container image tag (use task definition image)."`
-	Cpu          uint64 `help:"Task CPU limit."`
-	Memory       uint64 `help:"Task memory limit."`
-	UseTaskImage bool   `env:"DMTS_EXEC_USE_TASK_IMAGE" help:"Use task definition image."`
-	Detach       bool   `help:"Detach when the task starts."`
+	Profile      string        `env:"DMTS_PROFILE" short:"p" predictor:"profile" help:"Demitas profile name (e.g. prod/api)."`
+	Command      string        `env:"DMTS_EXEC_COMMAND" required:"" default:"bash" help:"Command to run on a container."`
+	Image        string        `env:"DMTS_EXEC_IMAGE" short:"i" default:"mirror.gcr.io/library/debian:stable-slim" help:"Container image."`
+	Tag          string        `help:"Container image tag (use task definition image)."`
+	Cpu          uint64        `help:"Task CPU limit."`
+	Memory       uint64        `help:"Task memory limit."`
+	UseTaskImage bool          `env:"DMTS_EXEC_USE_TASK_IMAGE" help:"Use task definition image."`
+	Detach       bool          `help:"Detach when the task starts."`
+	NoTty        bool          `name:"no-tty" help:"Run the command non-interactively and exit with its exit code (stderr is merged into stdout)."`
+	Container    string        `env:"DMTS_EXEC_CONTAINER" help:"Container name to execute a command on (default: main container)."`
+	TTL          time.Duration `env:"DMTS_TTL" help:"Maximum lifetime of the task (e.g. 8h). Commands other than \"sleep infinity\" are wrapped with timeout, which must be in the image."`
+	ReadyTimeout time.Duration `env:"DMTS_READY_TIMEOUT" default:"3m" help:"Timeout for waiting for ECS Exec to be ready."`
+	Reuse        bool          `env:"DMTS_REUSE" help:"Reuse a running task launched from the same definitions and keep the task running after exit."`
+	IdleTimeout  time.Duration `env:"DMTS_IDLE_TIMEOUT" default:"30m" help:"Idle time after which a reusable task is stopped."`
 }
 
 func (cmd *ExecCmd) Run(ctx *demitas2.Context) error {
@@ -36,7 +43,46 @@ func (cmd *ExecCmd) Run(ctx *demitas2.Context) error {
 		return err
 	}
 
-	taskId, interrupted, err := ctx.Ecspresso.RunUntilRunning(def, ctx.DryRun)
+	var pool *reusePool
+
+	if cmd.Reuse {
+		pool, err = newReusePool(ctx, def, cmd.IdleTimeout)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	err = applyTags(ctx, def, "exec", cmd.Profile, cmd.Command)
+
+	if err != nil {
+		return err
+	}
+
+	if pool != nil {
+		err = pool.apply(def, cmd.TTL)
+	} else {
+		err = applyTTL(def, cmd.TTL)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	var rec *launchRecord
+
+	// NOTE: Reusable tasks are kept running on purpose
+	if pool == nil {
+		rec, err = recordLaunch(ctx, def, cmd.Profile, "exec")
+
+		if err != nil {
+			return err
+		}
+	}
+
+	taskId, interrupted, err := rec.run(func() (string, bool, error) {
+		return runOrReuseTask(ctx, def, pool)
+	})
 
 	if err != nil {
 		return err
@@ -50,47 +96,86 @@ func (cmd *ExecCmd) Run(ctx *demitas2.Context) error {
 		return fmt.Errorf("task ID not found")
 	}
 
-	return utils.TrapInt(
+	container := cmd.Container
+
+	if container == "" {
+		container = def.MainContainer
+	}
+
+	stopHeartbeat := func() {}
+
+	if pool != nil && !interrupted {
+		stopHeartbeat = pool.heartbeat(taskId)
+	}
+
+	return ctx.TrapInt(
 		func() error {
 			if interrupted {
 				return nil
 			}
 
-			time.Sleep(3 * time.Second) // wait... :-(
-
-			for i := 0; i < 30; i++ {
-				err = ctx.Ecs.ExecuteCommand(def.Cluster, taskId, "id")
+			_, err := ctx.Ecs.GetContainerId(def.Cluster, taskId, container)
 
-				if err == nil {
-					break
-				}
-
-				time.Sleep(1 * time.Second)
+			if err != nil {
+				return err
 			}
 
+			err = ctx.Ecs.WaitForExecuteCommandAgent(context.Background(), def.Cluster, taskId, container, cmd.ReadyTimeout)
+
 			if err != nil {
 				return err
 			}
 
-			return ctx.Ecs.ExecuteInteractiveCommand(def.Cluster, taskId, cmd.Command)
+			if cmd.NoTty {
+				exitCode, err := ctx.Ecs.ExecuteNonInteractiveCommand(def.Cluster, taskId, container, cmd.Command, nil, os.Stdout)
+
+				if err != nil {
+					return err
+				}
+
+				if exitCode != 0 {
+					return &demitas2.ExitError{Code: exitCode}
+				}
+
+				return nil
+			}
+
+			return ctx.Ecs.ExecuteInteractiveCommand(def.Cluster, taskId, container, cmd.Command)
 		},
 		func() {
-			if cmd.Detach {
+			defer rec.done()
+
+			if cmd.Detach && !cmd.NoTty {
+				containerOpt := ""
+
+				// NOTE: ECS Exec requires --container only if the task has multiple containers
+				if container != "" {
+					containerOpt = " --container " + container
+				}
+
 				fmt.Printf(`ECS task is still running.
 
 Re-login command:
-  aws ecs execute-command --cluster %s --task %s --interactive --command %s
+  aws ecs execute-command --cluster %s --task %s%s --interactive --command %s
+  %s
 
 Task stop command:
   aws ecs stop-task --cluster %s --task %s
 `,
-					def.Cluster, taskId, cmd.Command,
+					def.Cluster, taskId, containerOpt, cmd.Command,
+					attachCommand(cmd.Profile, def.Cluster, container, taskId),
 					def.Cluster, taskId,
 				)
 
 				return
 			}
 
+			if pool != nil && !interrupted {
+				stopHeartbeat()
+				pool.release(taskId)
+				return
+			}
+
 			fmt.Printf("Stopping task: %s\n", taskId)
 			ctx.Ecs.StopTask(def.Cluster, taskId) //nolint:errcheck
 		})
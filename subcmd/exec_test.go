@@ -0,0 +1,165 @@
+package subcmd_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/kanmu/demitas2/subcmd"
+)
+
+func newExecCmd() *subcmd.ExecCmd {
+	return &subcmd.ExecCmd{
+		Profile:      "prod",
+		Command:      "bash",
+		Image:        "debian",
+		ReadyTimeout: time.Minute,
+	}
+}
+
+func TestExecInterruptDuringRunUntilRunning(t *testing.T) {
+	ctx, ecs := newTestContext(t)
+	ecs.Interrupt = true
+
+	err := newExecCmd().Run(ctx)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !ecs.Called("StopTask my-cluster/00000000000000000000000000000001") {
+		t.Errorf("task is not stopped: %v", ecs.Calls())
+	}
+
+	if ecs.Called("ExecuteInteractiveCommand") {
+		t.Errorf("command is executed after interrupt: %v", ecs.Calls())
+	}
+}
+
+func TestExecInterruptDuringSession(t *testing.T) {
+	ctx, ecs := newTestContext(t)
+	sig := make(chan os.Signal, 1)
+	exited := make(chan int, 1)
+	ctx.Interrupt = sig
+	ctx.Exit = func(code int) { exited <- code }
+	exitCode := -1
+
+	ecs.Hook = func(call string) {
+		if strings.HasPrefix(call, "ExecuteInteractiveCommand ") {
+			sig <- os.Interrupt
+			exitCode = <-exited
+		}
+	}
+
+	err := newExecCmd().Run(ctx)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if exitCode != 130 {
+		t.Errorf("expected exit code 130, got %d", exitCode)
+	}
+
+	task := ecs.Task("00000000000000000000000000000001")
+
+	if aws.ToString(task.LastStatus) != "STOPPED" {
+		t.Errorf("task is not stopped: %s", aws.ToString(task.LastStatus))
+	}
+}
+
+func TestExecStopsTaskOnExit(t *testing.T) {
+	ctx, ecs := newTestContext(t)
+
+	err := newExecCmd().Run(ctx)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	calls := ecs.Calls()
+
+	if len(calls) == 0 || calls[len(calls)-1] != "StopTask my-cluster/00000000000000000000000000000001" {
+		t.Errorf("task is not stopped at last: %v", calls)
+	}
+}
+
+func TestExecRejectsTTLUnderOneSecond(t *testing.T) {
+	ctx, ecs := newTestContext(t)
+	cmd := newExecCmd()
+	cmd.TTL = 500 * time.Millisecond
+
+	err := cmd.Run(ctx)
+
+	if err == nil || !strings.Contains(err.Error(), "TTL must be at least 1s") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if ecs.Called("RunUntilRunning") {
+		t.Errorf("task is launched: %v", ecs.Calls())
+	}
+}
+
+func TestExecRejectsInvalidTags(t *testing.T) {
+	ctx, ecs := newTestContext(t)
+	overrides := filepath.Join(ctx.DefinitionOpts.ConfDir, "prod", ".demitas.jsonnet")
+	err := os.WriteFile(overrides, []byte(`{tags: {"aws:team": "sre"}}`), 0o644)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = newExecCmd().Run(ctx)
+
+	if err == nil || !strings.Contains(err.Error(), "must not start with 'aws:'") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if ecs.Called("RunUntilRunning") {
+		t.Errorf("task is launched: %v", ecs.Calls())
+	}
+}
+
+func TestExecRejectsNonStringTags(t *testing.T) {
+	ctx, ecs := newTestContext(t)
+	overrides := filepath.Join(ctx.DefinitionOpts.ConfDir, "prod", ".demitas.jsonnet")
+	err := os.WriteFile(overrides, []byte(`{tags: {team: "sre", cost: 100}}`), 0o644)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = newExecCmd().Run(ctx)
+
+	if err == nil || !strings.Contains(err.Error(), "'tags.cost' must be a string") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if ecs.Called("RunUntilRunning") {
+		t.Errorf("task is launched: %v", ecs.Calls())
+	}
+}
+
+func TestExecRejectsTooManyTags(t *testing.T) {
+	ctx, ecs := newTestContext(t)
+	overrides := filepath.Join(ctx.DefinitionOpts.ConfDir, "prod", ".demitas.jsonnet")
+	// NOTE: 45 tags fit in the limit alone, but not with the tags of dmts
+	err := os.WriteFile(overrides, []byte(`{tags: {["tag%d" % i]: "v" for i in std.range(1, 45)}}`), 0o644)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = newExecCmd().Run(ctx)
+
+	if err == nil || !strings.Contains(err.Error(), "too many tags") || !strings.Contains(err.Error(), "'dmts:' tags") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if ecs.Called("RunUntilRunning") {
+		t.Errorf("task is launched: %v", ecs.Calls())
+	}
+}
@@ -0,0 +1,60 @@
+package subcmd
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/kanmu/demitas2"
+)
+
+type ExplainCmd struct {
+	Profile string `env:"DMTS_PROFILE" short:"p" predictor:"profile" help:"Demitas profile name (e.g. prod/api)."`
+	Command string `help:"Command to run on a container."`
+	Image   string `help:"Container image."`
+	Cpu     uint64 `help:"Task CPU limit."`
+	Memory  uint64 `help:"Task memory limit."`
+	Path    string `arg:"" help:"Field path (e.g. task_definition.containerDefinitions[0].image)."`
+}
+
+func (cmd *ExplainCmd) Run(ctx *demitas2.Context) error {
+	_, trace, err := ctx.DefinitionOpts.LoadWithTrace(cmd.Profile, cmd.Command, cmd.Image, cmd.Cpu, cmd.Memory, true)
+
+	if err != nil {
+		return err
+	}
+
+	expl, err := trace.Explain(cmd.Path)
+
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("# %s\n", expl.Path)
+
+	if len(expl.Changes) == 0 {
+		fmt.Println("(not set)")
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "LAYER\tSOURCE\tVALUE")
+
+	for _, c := range expl.Changes {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", c.Layer.Name, c.Layer.Source, explainValue(c.Value))
+	}
+
+	w.Flush()
+	last := expl.Changes[len(expl.Changes)-1]
+	fmt.Printf("\n=> %s (set by %s: %s)\n", explainValue(last.Value), last.Layer.Name, last.Layer.Source)
+
+	return nil
+}
+
+func explainValue(v string) string {
+	if v == "" {
+		return "(unset)"
+	}
+
+	return v
+}
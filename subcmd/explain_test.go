@@ -0,0 +1,55 @@
+package subcmd_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestExplainPointsToFileInExtendsChain(t *testing.T) {
+	ctx, _ := newTestContext(t)
+	confDir := ctx.DefinitionOpts.ConfDir
+	err := os.WriteFile(filepath.Join(confDir, "prod", ".demitas.jsonnet"), []byte(`{container_definition: {image: "parent"}}`), 0644)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.Mkdir(filepath.Join(confDir, "child"), 0755)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.WriteFile(filepath.Join(confDir, "child", ".demitas.jsonnet"), []byte(`{extends: "../prod", container_definition: {cpu: 256}}`), 0644)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, trace, err := ctx.DefinitionOpts.LoadWithTrace("child", "", "", 0, 0, false)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]string{
+		"container_definition.image": filepath.Join(confDir, "prod", ".demitas.jsonnet"),
+		"container_definition.cpu":   filepath.Join(confDir, "child", ".demitas.jsonnet"),
+	}
+
+	for path, file := range tests {
+		expl, err := trace.Explain(path)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		last := expl.Changes[len(expl.Changes)-1]
+
+		if !strings.HasPrefix(last.Layer.Source, file+"#") {
+			t.Errorf("%s: unexpected source: %s", path, last.Layer.Source)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package subcmd
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/kanmu/demitas2"
+	"github.com/kanmu/demitas2/definition"
+)
+
+type GcCmd struct {
+	Yes bool `short:"y" help:"Stop tasks without confirmation."`
+}
+
+func (cmd *GcCmd) Run(ctx *demitas2.Context) error {
+	state := newStateFile(ctx)
+	entries, err := state.list()
+
+	if err != nil {
+		return err
+	}
+
+	orphans := []*launchedTask{}
+
+	for _, entry := range entries {
+		if entry.orphaned() {
+			orphans = append(orphans, entry)
+		}
+	}
+
+	if len(orphans) == 0 {
+		fmt.Println("No tasks left behind.")
+		return nil
+	}
+
+	runIds := []string{}
+	targets := []*launchedTask{}
+	clusterTasks := map[string][]*demitasTask{}
+
+	for _, entry := range orphans {
+		runIds = append(runIds, entry.RunId)
+		status := ""
+
+		// NOTE: Find the task by the run ID tag if dmts was killed before the launcher returned the task ID
+		if entry.TaskId == "" {
+			tasks, ok := clusterTasks[entry.Cluster]
+
+			if !ok {
+				tasks, err = listDemitasTasks(ctx, entry.Cluster, false)
+
+				if err != nil {
+					return err
+				}
+
+				clusterTasks[entry.Cluster] = tasks
+			}
+
+			for _, task := range tasks {
+				if task.tag(definition.TagRunId) == entry.RunId {
+					entry.TaskId = task.id()
+				}
+			}
+
+			if entry.TaskId == "" {
+				// NOTE: The run ID tag is missing if the launcher does not propagate the tags to the task
+				for _, task := range tasks {
+					if task.tag(definition.TagRunId) == "" && task.launchedAfter(entry.CreatedAt) {
+						return fmt.Errorf("task of run ID %s cannot be identified because task %s is not tagged with '%s' (stop it with `dmts stop` if it is left behind)", entry.RunId, task.id(), definition.TagRunId)
+					}
+				}
+
+				status = "not running"
+			}
+		} else {
+			task, err := ctx.Ecs.DescribeTask(entry.Cluster, entry.TaskId)
+
+			if err != nil {
+				return err
+			}
+
+			if aws.ToString(task.LastStatus) == "STOPPED" {
+				status = "stopped"
+			}
+		}
+
+		taskId := entry.TaskId
+
+		if taskId == "" {
+			taskId = "-"
+		}
+
+		fmt.Printf("%s  %s  %s  %s  %s", taskId, entry.Cluster, entry.Profile, entry.Mode, entry.CreatedAt.Local().Format("2006-01-02 15:04:05"))
+
+		if status != "" {
+			fmt.Printf("  (%s)\n", status)
+			continue
+		}
+
+		fmt.Println()
+		targets = append(targets, entry)
+	}
+
+	if len(targets) > 0 {
+		if !cmd.Yes && !confirm(fmt.Sprintf("Stop %d task(s)?", len(targets))) {
+			return nil
+		}
+
+		for _, entry := range targets {
+			fmt.Printf("Stopping task: %s\n", entry.TaskId)
+			err = ctx.Ecs.StopTask(entry.Cluster, entry.TaskId)
+
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return state.remove(runIds...)
+}
@@ -0,0 +1,97 @@
+package subcmd_test
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
+	"github.com/kanmu/demitas2"
+	"github.com/kanmu/demitas2/definition"
+	"github.com/kanmu/demitas2/fake"
+	"github.com/kanmu/demitas2/subcmd"
+)
+
+// NOTE: Record an entry of a killed dmts process that did not know the task ID
+func writeOrphanedEntry(t *testing.T, ctx *demitas2.Context, runId string) string {
+	t.Helper()
+	hostname, _ := os.Hostname()
+	entries := []map[string]any{{
+		"run_id":     runId,
+		"cluster":    "my-cluster",
+		"profile":    "prod",
+		"mode":       "exec",
+		"pid":        1 << 30,
+		"hostname":   hostname,
+		"created_at": time.Now().Add(-time.Minute),
+	}}
+	content, _ := json.Marshal(entries)
+	path := filepath.Join(ctx.DefinitionOpts.ConfDir, ".dmts-state.json")
+	err := os.WriteFile(path, content, 0o600)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func addLaunchedTask(ecs *fake.ECS, taskId string, tags []types.Tag) {
+	ecs.AddTask(&types.Task{
+		TaskArn:           aws.String("arn:aws:ecs:ap-northeast-1:123456789012:task/my-cluster/" + taskId),
+		ClusterArn:        aws.String("arn:aws:ecs:ap-northeast-1:123456789012:cluster/my-cluster"),
+		TaskDefinitionArn: aws.String("arn:aws:ecs:ap-northeast-1:123456789012:task-definition/" + definition.FamilyPrefix() + "my-app:1"),
+		LastStatus:        aws.String("PENDING"),
+		DesiredStatus:     aws.String("RUNNING"),
+		CreatedAt:         aws.Time(time.Now()),
+		Tags:              tags,
+	}, nil)
+}
+
+func TestGcStopsTaskByRunId(t *testing.T) {
+	ctx, ecs := newTestContext(t)
+	path := writeOrphanedEntry(t, ctx, "0123456789abcdef")
+	addLaunchedTask(ecs, "tagged", []types.Tag{{Key: aws.String(definition.TagRunId), Value: aws.String("0123456789abcdef")}})
+
+	err := (&subcmd.GcCmd{Yes: true}).Run(ctx)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !ecs.Called("StopTask my-cluster/tagged") {
+		t.Errorf("task is not stopped: %v", ecs.Calls())
+	}
+
+	content, _ := os.ReadFile(path)
+
+	if strings.Contains(string(content), "0123456789abcdef") {
+		t.Errorf("entry is not removed: %s", content)
+	}
+}
+
+func TestGcFailsOnUntaggedTask(t *testing.T) {
+	ctx, ecs := newTestContext(t)
+	path := writeOrphanedEntry(t, ctx, "0123456789abcdef")
+	addLaunchedTask(ecs, "untagged", nil)
+
+	err := (&subcmd.GcCmd{Yes: true}).Run(ctx)
+
+	if err == nil || !strings.Contains(err.Error(), "untagged") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if ecs.Called("StopTask") {
+		t.Errorf("task is stopped: %v", ecs.Calls())
+	}
+
+	content, _ := os.ReadFile(path)
+
+	if !strings.Contains(string(content), "0123456789abcdef") {
+		t.Errorf("entry is removed: %s", content)
+	}
+}
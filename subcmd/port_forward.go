@@ -1,29 +1,220 @@
 package subcmd
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/kanmu/demitas2"
-	"github.com/kanmu/demitas2/utils"
+	"github.com/kanmu/demitas2/definition"
+	"go.uber.org/atomic"
 )
 
+const localPortTimeout = 60 * time.Second
+
 type PortForwardCmd struct {
-	Profile    string `env:"DMTS_PROFILE" short:"p" help:"Demitas profile name."`
-	RemoteHost string `required:"" short:"H" help:"Remote host."`
-	RemotePort uint   `required:"" short:"r"  help:"Remote port."`
-	LocalPort  uint   `required:"" short:"l"  help:"Local port."`
-	Image      string `short:"i" default:"mirror.gcr.io/library/debian:stable-slim" help:"Container image."`
+	Profile      string                 `env:"DMTS_PROFILE" short:"p" predictor:"profile" help:"Demitas profile name (e.g. prod/api)."`
+	RemoteHost   string                 `short:"H" help:"Remote host."`
+	RemotePort   uint                   `short:"r"  help:"Remote port."`
+	LocalPort    uint                   `short:"l"  help:"Local port (default: free port)."`
+	Forwards     []demitas2.PortForward `name:"forward" short:"L" sep:"none" placeholder:"LOCAL:HOST:REMOTE" help:"Port forwarding spec (repeatable)."`
+	Image        string                 `short:"i" default:"mirror.gcr.io/library/debian:stable-slim" help:"Container image."`
+	Container    string                 `help:"Container name to forward a port through (default: main container)."`
+	TTL          time.Duration          `env:"DMTS_TTL" help:"Maximum lifetime of the task (e.g. 8h). Commands other than \"sleep infinity\" are wrapped with timeout, which must be in the image."`
+	ReadyTimeout time.Duration          `env:"DMTS_READY_TIMEOUT" default:"3m" help:"Timeout for waiting for ECS Exec to be ready."`
+	Reuse        bool                   `env:"DMTS_REUSE" help:"Reuse a running task launched from the same definitions and keep the task running after exit."`
+	IdleTimeout  time.Duration          `env:"DMTS_IDLE_TIMEOUT" default:"30m" help:"Idle time after which a reusable task is stopped."`
+	Targets      []string               `arg:"" optional:"" passthrough:"partial" help:"Names of port forwarding targets in the overrides file, and a command to run after \"--\"."`
+}
+
+// NOTE: Split positional arguments into target names and a command after "--"
+func (cmd *PortForwardCmd) splitCommand() ([]string, []string, error) {
+	names := cmd.Targets
+	var command []string
+
+	for i, arg := range cmd.Targets {
+		if arg == "--" {
+			names = cmd.Targets[:i]
+			command = cmd.Targets[i+1:]
+			break
+		}
+	}
+
+	for _, name := range names {
+		if strings.HasPrefix(name, "-") {
+			return nil, nil, fmt.Errorf("flags must precede target names: %s", name)
+		}
+	}
+
+	if slices.Contains(cmd.Targets, "--") && len(command) == 0 {
+		return nil, nil, fmt.Errorf("command is empty after \"--\"")
+	}
+
+	return names, command, nil
+}
+
+// NOTE: The local ports are reserved until the reservation is released
+func (cmd *PortForwardCmd) forwards(overrides *definition.Overrides, names []string) ([]demitas2.PortForward, portReservation, error) {
+	forwards := append([]demitas2.PortForward{}, cmd.Forwards...)
+
+	for _, name := range names {
+		target, err := overrides.PortForward(name)
+
+		if err != nil {
+			return nil, nil, err
+		}
+
+		forwards = append(forwards, demitas2.PortForward{
+			LocalPort:  target.LocalPort,
+			RemoteHost: target.Host,
+			RemotePort: target.RemotePort,
+		})
+	}
+
+	if cmd.RemoteHost != "" || cmd.RemotePort != 0 || cmd.LocalPort != 0 {
+		if cmd.RemoteHost == "" || cmd.RemotePort == 0 {
+			return nil, nil, fmt.Errorf("--remote-host and --remote-port must be specified together")
+		}
+
+		forwards = append(forwards, demitas2.PortForward{
+			LocalPort:  cmd.LocalPort,
+			RemoteHost: cmd.RemoteHost,
+			RemotePort: cmd.RemotePort,
+		})
+	}
+
+	if len(forwards) == 0 {
+		return nil, nil, fmt.Errorf("target name, -L/--forward or --remote-host and --remote-port is required")
+	}
+
+	localPorts := map[uint]bool{}
+
+	for _, pf := range forwards {
+		if pf.LocalPort != 0 && localPorts[pf.LocalPort] {
+			return nil, nil, fmt.Errorf("local port is duplicated: %d", pf.LocalPort)
+		}
+
+		localPorts[pf.LocalPort] = true
+	}
+
+	reservation := portReservation{}
+
+	for i, pf := range forwards {
+		// NOTE: Keep the local port bound while launching the task so that no one else takes it
+		listener, err := reserveLocalPort(pf.LocalPort)
+
+		if err != nil {
+			reservation.release()
+			return nil, nil, err
+		}
+
+		reservation = append(reservation, listener)
+		localPort := uint(listener.Addr().(*net.TCPAddr).Port)
+
+		if pf.LocalPort == 0 {
+			fmt.Printf("Local port %d is selected for %s:%d\n", localPort, pf.RemoteHost, pf.RemotePort)
+		}
+
+		forwards[i].LocalPort = localPort
+	}
+
+	return forwards, reservation, nil
+}
+
+// portReservation is the listeners of the local ports reserved for port forwarding.
+type portReservation []net.Listener
+
+// NOTE: Release just before port forwarding binds the ports
+func (reservation portReservation) release() {
+	for _, listener := range reservation {
+		listener.Close()
+	}
+}
+
+// NOTE: Bind a free port if the port is 0
+func reserveLocalPort(port uint) (net.Listener, error) {
+	listener, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+
+	if err != nil {
+		return nil, fmt.Errorf("local port is not available: %w", err)
+	}
+
+	return listener, nil
 }
 
 func (cmd *PortForwardCmd) Run(ctx *demitas2.Context) error {
+	names, command, err := cmd.splitCommand()
+
+	if err != nil {
+		return err
+	}
+
 	def, err := ctx.DefinitionOpts.Load(cmd.Profile, "sleep infinity", cmd.Image, 0, 0, true)
 
 	if err != nil {
 		return err
 	}
 
-	taskId, interrupted, err := ctx.Ecspresso.RunUntilRunning(def, ctx.DryRun)
+	var pool *reusePool
+
+	if cmd.Reuse {
+		pool, err = newReusePool(ctx, def, cmd.IdleTimeout)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	forwards, reservation, err := cmd.forwards(def.Overrides, names)
+
+	if err != nil {
+		return err
+	}
+
+	defer reservation.release()
+
+	targets := []string{}
+
+	for _, pf := range forwards {
+		targets = append(targets, fmt.Sprintf("%s:%d", pf.RemoteHost, pf.RemotePort))
+	}
+
+	err = applyTags(ctx, def, "port-forward", cmd.Profile, strings.Join(targets, " "))
+
+	if err != nil {
+		return err
+	}
+
+	if pool != nil {
+		err = pool.apply(def, cmd.TTL)
+	} else {
+		err = applyTTL(def, cmd.TTL)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	var rec *launchRecord
+
+	// NOTE: Reusable tasks are kept running on purpose
+	if pool == nil {
+		rec, err = recordLaunch(ctx, def, cmd.Profile, "port-forward")
+
+		if err != nil {
+			return err
+		}
+	}
+
+	taskId, interrupted, err := rec.run(func() (string, bool, error) {
+		return runOrReuseTask(ctx, def, pool)
+	})
 
 	if err != nil {
 		return err
@@ -37,25 +228,148 @@ func (cmd *PortForwardCmd) Run(ctx *demitas2.Context) error {
 		return fmt.Errorf("task ID not found")
 	}
 
-	return utils.TrapInt(
-		func() error {
-			if interrupted {
-				return nil
-			}
+	container := cmd.Container
+
+	if container == "" {
+		container = def.MainContainer
+	}
 
-			containerId, err := ctx.Ecs.GetContainerId(def.Cluster, taskId)
+	stopHeartbeat := func() {}
 
-			if err != nil {
-				return fmt.Errorf("failed to get ID from container: %w", err)
+	if pool != nil && !interrupted {
+		stopHeartbeat = pool.heartbeat(taskId)
+	}
+
+	fwdCtx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sig, stopNotify := ctx.NotifyInterrupt()
+	defer stopNotify()
+	signaled := atomic.NewBool(false)
+	childRunning := atomic.NewBool(false)
+
+	// NOTE: SIGINT cancels port forwarding, and the task is stopped after the sessions return
+	go func() {
+		for {
+			select {
+			case <-sig:
+				// NOTE: Let the command handle Ctrl-C
+				if childRunning.Load() {
+					continue
+				}
+
+				signaled.Store(true)
+				cancel()
+
+				return
+			case <-fwdCtx.Done():
+				return
 			}
+		}
+	}()
+
+	err = func() error {
+		if interrupted {
+			return nil
+		}
+
+		containerId, err := ctx.Ecs.GetContainerId(def.Cluster, taskId, container)
 
-			time.Sleep(3 * time.Second) // wait... :-(
-			fmt.Println("Start port forwarding...")
-			return ctx.Ecs.StartPortForwardingSessionToRemoteHost(def.Cluster, taskId, containerId, cmd.RemoteHost, cmd.RemotePort, cmd.LocalPort)
-		},
-		func() {
-			fmt.Printf("Stopping task: %s\n", taskId)
-			ctx.Ecs.StopTask(def.Cluster, taskId) //nolint:errcheck
-		},
+		if err != nil {
+			return fmt.Errorf("failed to get ID from container: %w", err)
+		}
+
+		err = ctx.Ecs.WaitForExecuteCommandAgent(fwdCtx, def.Cluster, taskId, container, cmd.ReadyTimeout)
+
+		if err != nil {
+			return err
+		}
+
+		fmt.Println("Start port forwarding...")
+		reservation.release()
+
+		if len(command) == 0 {
+			return ctx.Ecs.StartPortForwardingSessions(fwdCtx, def.Cluster, taskId, containerId, forwards, nil)
+		}
+
+		return runWithPortForwarding(fwdCtx, ctx, def.Cluster, taskId, containerId, forwards, command, childRunning)
+	}()
+
+	cancel()
+	rec.done()
+
+	if pool != nil && !interrupted {
+		stopHeartbeat()
+		pool.release(taskId)
+	} else {
+		fmt.Printf("Stopping task: %s\n", taskId)
+		ctx.Ecs.StopTask(def.Cluster, taskId) //nolint:errcheck
+	}
+
+	if signaled.Load() && (err == nil || errors.Is(err, context.Canceled)) {
+		return &demitas2.ExitError{Code: 130}
+	}
+
+	return err
+}
+
+// NOTE: childRunning is set while the command is running
+func runWithPortForwarding(parent context.Context, ctx *demitas2.Context, cluster string, taskId string, containerId string, forwards []demitas2.PortForward, command []string, childRunning *atomic.Bool) error {
+	fwdCtx, cancel := context.WithCancel(parent)
+	fwdDone := make(chan error, 1)
+	ready := make(chan struct{})
+
+	go func() {
+		fwdDone <- ctx.Ecs.StartPortForwardingSessions(fwdCtx, cluster, taskId, containerId, forwards, ready)
+	}()
+
+	defer func() {
+		cancel()
+		<-fwdDone
+	}()
+
+	// NOTE: Wait until all local ports accept connections
+	select {
+	case <-ready:
+	case err := <-fwdDone:
+		// NOTE: Do not wait for fwdDone again
+		fwdDone <- err
+
+		if err == nil {
+			err = fmt.Errorf("port forwarding stopped")
+		}
+
+		return fmt.Errorf("failed to forward local ports: %w", err)
+	case <-time.After(localPortTimeout):
+		return fmt.Errorf("timed out waiting for port forwarding to be ready")
+	}
+
+	localPorts := []string{}
+
+	for _, pf := range forwards {
+		localPorts = append(localPorts, fmt.Sprint(pf.LocalPort))
+	}
+
+	child := exec.Command(command[0], command[1:]...)
+	child.Stdin = os.Stdin
+	child.Stdout = os.Stdout
+	child.Stderr = os.Stderr
+	child.Env = append(os.Environ(),
+		"DMTS_LOCAL_HOST=127.0.0.1",
+		"DMTS_LOCAL_PORT="+localPorts[0],
+		"DMTS_LOCAL_PORTS="+strings.Join(localPorts, ","),
 	)
+
+	childRunning.Store(true)
+	err := child.Run()
+	childRunning.Store(false)
+
+	var exitErr *exec.ExitError
+
+	if errors.As(err, &exitErr) {
+		return &demitas2.ExitError{Code: exitErr.ExitCode()}
+	} else if err != nil {
+		return fmt.Errorf("failed to run command: %w", err)
+	}
+
+	return nil
 }
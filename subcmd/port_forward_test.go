@@ -0,0 +1,103 @@
+package subcmd_test
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/kanmu/demitas2"
+	"github.com/kanmu/demitas2/subcmd"
+)
+
+func TestPortForwardInterruptStopsTaskAfterSessions(t *testing.T) {
+	ctx, ecs := newTestContext(t)
+	sig := make(chan os.Signal, 1)
+	ctx.Interrupt = sig
+
+	ecs.Hook = func(call string) {
+		if strings.HasPrefix(call, "StartPortForwardingSessions ") {
+			sig <- os.Interrupt
+		}
+	}
+
+	cmd := &subcmd.PortForwardCmd{
+		Profile:    "prod",
+		RemoteHost: "db.example.com",
+		RemotePort: 5432,
+		Image:      "debian",
+	}
+
+	err := cmd.Run(ctx)
+	var exitErr *demitas2.ExitError
+
+	if !errors.As(err, &exitErr) || exitErr.Code != 130 {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	calls := ecs.Calls()
+	last := calls[len(calls)-1]
+
+	if last != "StopTask my-cluster/00000000000000000000000000000001" {
+		t.Errorf("task is not stopped after the sessions: %v", calls)
+	}
+}
+
+func TestPortForwardSelectsDistinctLocalPorts(t *testing.T) {
+	ctx, ecs := newTestContext(t)
+	sig := make(chan os.Signal, 1)
+	ctx.Interrupt = sig
+	var specs []string
+
+	ecs.Hook = func(call string) {
+		if strings.HasPrefix(call, "StartPortForwardingSessions ") {
+			specs = strings.Split(call[strings.LastIndex(call, "/")+1:], ",")
+			sig <- os.Interrupt
+		}
+	}
+
+	cmd := &subcmd.PortForwardCmd{
+		Profile: "prod",
+		Forwards: []demitas2.PortForward{
+			{RemoteHost: "db.example.com", RemotePort: 5432},
+			{RemoteHost: "redis.example.com", RemotePort: 6379},
+		},
+		Image: "debian",
+	}
+
+	_ = cmd.Run(ctx)
+
+	if len(specs) != 2 {
+		t.Fatalf("unexpected forwards: %v", specs)
+	}
+
+	local0, _, _ := strings.Cut(specs[0], ":")
+	local1, _, _ := strings.Cut(specs[1], ":")
+
+	if local0 == "0" || local0 == local1 {
+		t.Errorf("local ports are not distinct: %v", specs)
+	}
+}
+
+func TestPortForwardRunsCommandWhenReady(t *testing.T) {
+	ctx, ecs := newTestContext(t)
+
+	cmd := &subcmd.PortForwardCmd{
+		Profile:    "prod",
+		RemoteHost: "db.example.com",
+		RemotePort: 5432,
+		Image:      "debian",
+		Targets:    []string{"--", "sh", "-c", `test -n "$DMTS_LOCAL_PORT" && exit 3`},
+	}
+
+	err := cmd.Run(ctx)
+	var exitErr *demitas2.ExitError
+
+	if !errors.As(err, &exitErr) || exitErr.Code != 3 {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !ecs.Called("StopTask my-cluster/00000000000000000000000000000001") {
+		t.Errorf("task is not stopped: %v", ecs.Calls())
+	}
+}
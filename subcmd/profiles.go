@@ -1,28 +1,136 @@
 package subcmd
 
 import (
+	"encoding/json"
 	"fmt"
 	"os"
+	"strings"
+	"text/tabwriter"
 
 	"github.com/kanmu/demitas2"
+	"github.com/kanmu/demitas2/definition"
 )
 
 type ProfilesCmd struct {
+	Long bool `short:"l" help:"Show cluster, region, task family, image, cpu/memory, launch type, port forwarding targets and description of each profile."`
+	Json bool `help:"Print the details of each profile in JSON."`
+}
+
+type profileSummary struct {
+	Name string `json:"name"`
+	*definition.Summary
+	Error string `json:"error,omitempty"`
 }
 
 func (cmd *ProfilesCmd) Run(ctx *demitas2.Context) error {
+	profiles, err := ctx.DefinitionOpts.Profiles()
+
+	if err != nil {
+		return err
+	}
+
+	if cmd.Long || cmd.Json {
+		return cmd.printSummaries(ctx, profiles)
+	}
+
 	fmt.Printf("# conf-dir: %s\n", ctx.DefinitionOpts.ConfDir)
-	files, err := os.ReadDir(ctx.DefinitionOpts.ExpandConfDir())
+
+	// NOTE: Print port forwarding targets on stderr so that stdout has only profile names
+	for _, profile := range profiles {
+		fmt.Println(profile)
+		targets, err := ctx.DefinitionOpts.PortForwards(profile)
+
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "  (%s)\n", err)
+			continue
+		}
+
+		for _, t := range targets {
+			fmt.Fprintf(os.Stderr, "  %s: localhost:%d -> %s:%d\n", t.Name, t.LocalPort, t.Host, t.RemotePort)
+		}
+	}
+
+	return nil
+}
+
+// NOTE: Errors of each profile are reported without aborting
+func (cmd *ProfilesCmd) printSummaries(ctx *demitas2.Context, profiles []string) error {
+	summaries := []*profileSummary{}
+
+	for _, profile := range profiles {
+		summary := &profileSummary{Name: profile}
+		summaries = append(summaries, summary)
+		def, err := ctx.DefinitionOpts.Load(profile, "", "", 0, 0, false)
+
+		if err != nil {
+			summary.Error = err.Error()
+			continue
+		}
+
+		summary.Summary, err = def.Summary()
+
+		if err != nil {
+			summary.Error = err.Error()
+		}
+	}
+
+	if cmd.Json {
+		js, err := json.MarshalIndent(summaries, "", "  ")
+
+		if err != nil {
+			panic(err)
+		}
+
+		fmt.Println(string(js))
+
+		return nil
+	}
+
+	fmt.Printf("# conf-dir: %s\n", ctx.DefinitionOpts.ConfDir)
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "PROFILE\tCLUSTER\tREGION\tFAMILY\tIMAGE\tCPU\tMEMORY\tLAUNCH TYPE\tPORT FORWARDS\tDESCRIPTION")
+
+	for _, s := range summaries {
+		if s.Summary == nil {
+			fmt.Fprintf(w, "%s\t-\t-\t-\t-\t-\t-\t-\t-\t(error)\n", s.Name)
+			continue
+		}
+
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			s.Name, orDash(s.Cluster), orDash(s.Region), orDash(s.Family), orDash(s.Image),
+			orDash(s.Cpu), orDash(s.Memory), orDash(s.LaunchType), orDash(formatPortForwards(s.PortForwards)), orDash(s.Description))
+	}
+
+	err := w.Flush()
 
 	if err != nil {
-		return fmt.Errorf("failed to list profiles: %w", err)
+		return err
 	}
 
-	for _, f := range files {
-		if f.IsDir() {
-			fmt.Println(f.Name())
+	for _, s := range summaries {
+		if s.Error != "" {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", s.Name, s.Error)
 		}
 	}
 
 	return nil
 }
+
+// NOTE: e.g. "db(localhost:5432->db.internal:5432)"
+func formatPortForwards(targets []*definition.PortForwardTarget) string {
+	specs := []string{}
+
+	for _, t := range targets {
+		specs = append(specs, fmt.Sprintf("%s(localhost:%d->%s:%d)", t.Name, t.LocalPort, t.Host, t.RemotePort))
+	}
+
+	return strings.Join(specs, ",")
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+
+	return s
+}
@@ -0,0 +1,102 @@
+package subcmd_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/kanmu/demitas2/subcmd"
+)
+
+func TestProfilesListsTargetsOnStderr(t *testing.T) {
+	ctx, _ := newTestContext(t)
+	overrides := `{port_forwards: {db: {host: "db.internal", remote_port: 5432}}}`
+	err := os.WriteFile(filepath.Join(ctx.DefinitionOpts.ConfDir, "prod", ".demitas.jsonnet"), []byte(overrides), 0644)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stdout, stderr := captureOutput(t, func() {
+		err = (&subcmd.ProfilesCmd{}).Run(ctx)
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(stdout), "\n")
+
+	if len(lines) != 3 || lines[1] != "prod" || lines[2] != "staging/api" {
+		t.Errorf("unexpected stdout: %q", stdout)
+	}
+
+	// NOTE: staging/api extends prod
+	if stderr != "  db: localhost:5432 -> db.internal:5432\n  db: localhost:5432 -> db.internal:5432\n" {
+		t.Errorf("unexpected stderr: %q", stderr)
+	}
+}
+
+func TestProfilesListsNestedProfiles(t *testing.T) {
+	ctx, _ := newTestContext(t)
+	var err error
+
+	stdout, _ := captureOutput(t, func() {
+		err = (&subcmd.ProfilesCmd{}).Run(ctx)
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// NOTE: "shared" has no ecspresso config and ".archive" is hidden
+	expected := "# conf-dir: " + ctx.DefinitionOpts.ConfDir + "\nprod\nstaging/api\n"
+
+	if stdout != expected {
+		t.Errorf("unexpected stdout: %q", stdout)
+	}
+}
+
+func TestProfilesLongShowsPortForwards(t *testing.T) {
+	ctx, _ := newTestContext(t)
+	overrides := `{port_forwards: {db: {host: "db.internal", remote_port: 5432, local_port: 15432}}}`
+	err := os.WriteFile(filepath.Join(ctx.DefinitionOpts.ConfDir, "prod", ".demitas.jsonnet"), []byte(overrides), 0644)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stdout, _ := captureOutput(t, func() {
+		err = (&subcmd.ProfilesCmd{Long: true}).Run(ctx)
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(stdout, "PORT FORWARDS") || !strings.Contains(stdout, "db(localhost:15432->db.internal:5432)") {
+		t.Errorf("port forwarding targets are not shown: %q", stdout)
+	}
+
+	stdout, _ = captureOutput(t, func() {
+		err = (&subcmd.ProfilesCmd{Json: true}).Run(ctx)
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `"port_forwards": [
+      {
+        "name": "db",
+        "host": "db.internal",
+        "remote_port": 5432,
+        "local_port": 15432
+      }
+    ]`
+
+	if !strings.Contains(stdout, expected) {
+		t.Errorf("port forwarding targets are not printed in JSON: %s", stdout)
+	}
+}
@@ -0,0 +1,51 @@
+package subcmd
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/kanmu/demitas2"
+)
+
+type PsCmd struct {
+	Profile string            `env:"DMTS_PROFILE" short:"p" predictor:"profile" help:"Demitas profile name (e.g. prod/api)."`
+	Mine    bool              `help:"List only my tasks."`
+	Tag     map[string]string `help:"List only tasks with the tag (e.g. --tag dmts:subcommand=exec)."`
+}
+
+func (cmd *PsCmd) Run(ctx *demitas2.Context) error {
+	def, err := ctx.DefinitionOpts.Load(cmd.Profile, "", "", 0, 0, false)
+
+	if err != nil {
+		return err
+	}
+
+	tasks, err := listDemitasTasks(ctx, def.Cluster, !cmd.Mine)
+
+	if err != nil {
+		return err
+	}
+
+	err = describeTaskDefinitions(ctx, tasks)
+
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("# cluster: %s\n", def.Cluster)
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TASK ID\tOWNER\tSTATUS\tSTARTED\tREMAINING\tIMAGE\tCOMMAND")
+
+	for _, task := range tasks {
+		if !task.hasTags(cmd.Tag) {
+			continue
+		}
+
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			task.id(), task.owner(), aws.ToString(task.LastStatus), task.startedAt(), task.remaining(), task.image(), task.command())
+	}
+
+	return w.Flush()
+}
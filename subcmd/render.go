@@ -0,0 +1,106 @@
+package subcmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	jsonpatch "github.com/evanphx/json-patch"
+	"github.com/kanmu/demitas2"
+	"github.com/kanmu/demitas2/utils"
+)
+
+type RenderCmd struct {
+	Profile   string `env:"DMTS_PROFILE" short:"p" predictor:"profile" help:"Demitas profile name (e.g. prod/api)."`
+	Command   string `help:"Command to run on a container."`
+	Image     string `help:"Container image."`
+	Cpu       uint64 `help:"Task CPU limit."`
+	Memory    uint64 `help:"Task memory limit."`
+	Format    string `short:"f" enum:"json,yaml" default:"json" help:"Output format (json, yaml)."`
+	OutputDir string `short:"o" type:"path" help:"Write definitions to files in the directory."`
+}
+
+func (cmd *RenderCmd) Run(ctx *demitas2.Context) error {
+	def, err := ctx.DefinitionOpts.Load(cmd.Profile, cmd.Command, cmd.Image, cmd.Cpu, cmd.Memory, true)
+
+	if err != nil {
+		return err
+	}
+
+	if cmd.OutputDir != "" {
+		return cmd.writeFiles(def.EcspressoConfig.Content, def.Service.Content, def.Task.Content)
+	}
+
+	js := fmt.Sprintf(`{"ecspresso_config":%s,"service_definition":%s,"task_definition":%s}`,
+		def.EcspressoConfig.Content, def.Service.Content, def.Task.Content)
+	out, err := cmd.format([]byte(js))
+
+	if err != nil {
+		return err
+	}
+
+	fmt.Print(out)
+
+	return nil
+}
+
+func (cmd *RenderCmd) writeFiles(ecsConf []byte, svrDef []byte, taskDef []byte) error {
+	err := os.MkdirAll(cmd.OutputDir, os.FileMode(0o755))
+
+	if err != nil {
+		return fmt.Errorf("failed to create output directory: %w", err)
+	}
+
+	ecsConfFile := "ecspresso." + cmd.Format
+	serviceDefFile := "ecs-service-def." + cmd.Format
+	taskDefFile := "ecs-task-def." + cmd.Format
+
+	// NOTE: Point the ecspresso config to the rendered definitions
+	ecsConf, err = jsonpatch.MergePatch(ecsConf, []byte(`{"service_definition":"`+serviceDefFile+`","task_definition":"`+taskDefFile+`"}`))
+
+	if err != nil {
+		return fmt.Errorf("failed to update ecspresso config: %w", err)
+	}
+
+	files := []struct {
+		name    string
+		content []byte
+	}{
+		{ecsConfFile, ecsConf},
+		{serviceDefFile, svrDef},
+		{taskDefFile, taskDef},
+	}
+
+	for _, f := range files {
+		out, err := cmd.format(f.content)
+
+		if err != nil {
+			return err
+		}
+
+		path := filepath.Join(cmd.OutputDir, f.name)
+		err = os.WriteFile(path, []byte(out), os.FileMode(0o644))
+
+		if err != nil {
+			return fmt.Errorf("failed to write definition: %w: %s", err, path)
+		}
+
+		fmt.Println(path)
+	}
+
+	return nil
+}
+
+func (cmd *RenderCmd) format(js []byte) (string, error) {
+	if cmd.Format == "yaml" {
+		ym, err := utils.JSONToYAML(js)
+
+		if err != nil {
+			return "", fmt.Errorf("failed to convert definition to YAML: %w", err)
+		}
+
+		return string(ym), nil
+	}
+
+	return utils.PrettyJSON(js) + "\n", nil
+}
@@ -0,0 +1,35 @@
+package subcmd_test
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/kanmu/demitas2/definition"
+	"github.com/kanmu/demitas2/subcmd"
+)
+
+func TestRender(t *testing.T) {
+	ctx, _ := newTestContext(t)
+	var err error
+
+	stdout, _ := captureOutput(t, func() {
+		err = (&subcmd.RenderCmd{Profile: "prod", Command: "bash", Image: "debian", Format: "json"}).Run(ctx)
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// NOTE: The family prefix depends on the user
+	stdout = strings.ReplaceAll(stdout, definition.FamilyPrefix(), "dmts-USER-")
+	golden, err := os.ReadFile("testdata/render.golden.json")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if stdout != string(golden) {
+		t.Errorf("rendered definitions differ from testdata/render.golden.json:\n%s", stdout)
+	}
+}
@@ -0,0 +1,207 @@
+package subcmd
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/kanmu/demitas2"
+	"github.com/kanmu/demitas2/definition"
+)
+
+const (
+	// NOTE: Reusable tasks are stopped by the TTL even if no one stops them
+	defaultReuseTTL        = 8 * time.Hour
+	reuseHeartbeatInterval = time.Minute
+)
+
+// reusePool finds a running task launched from the same definitions
+// and stops reusable tasks that have been idle for too long.
+type reusePool struct {
+	ctx         *demitas2.Context
+	cluster     string
+	hash        string
+	idleTimeout time.Duration
+}
+
+// NOTE: Must be called before tags and TTL are applied
+func newReusePool(ctx *demitas2.Context, def *definition.Definition, idleTimeout time.Duration) (*reusePool, error) {
+	if idleTimeout <= 0 {
+		return nil, fmt.Errorf("idle timeout must be positive: %s", idleTimeout)
+	}
+
+	hash, err := def.Hash()
+
+	if err != nil {
+		return nil, err
+	}
+
+	pool := &reusePool{
+		ctx:         ctx,
+		cluster:     def.Cluster,
+		hash:        hash,
+		idleTimeout: idleTimeout,
+	}
+
+	return pool, nil
+}
+
+func (pool *reusePool) tags() map[string]string {
+	return map[string]string{
+		definition.TagReuse:       pool.hash,
+		definition.TagLastUsed:    time.Now().UTC().Format(time.RFC3339),
+		definition.TagIdleTimeout: pool.idleTimeout.String(),
+	}
+}
+
+// acquire stops my idle reusable tasks and returns the ID of the latest running task
+// with the same definition hash, or an empty string if there is none.
+func (pool *reusePool) acquire() (string, error) {
+	tasks, err := listDemitasTasks(pool.ctx, pool.cluster, false)
+
+	if err != nil {
+		return "", err
+	}
+
+	taskId := ""
+
+	for _, task := range tasks {
+		if task.tag(definition.TagReuse) == "" {
+			continue
+		}
+
+		if task.idle(pool.idleTimeout) {
+			fmt.Printf("Stopping idle task: %s\n", task.id())
+			err := pool.ctx.Ecs.StopTask(pool.cluster, task.id())
+
+			if err != nil {
+				return "", err
+			}
+
+			continue
+		}
+
+		// NOTE: Tasks are sorted by the start time
+		if task.tag(definition.TagReuse) == pool.hash && aws.ToString(task.LastStatus) == "RUNNING" {
+			taskId = task.id()
+		}
+	}
+
+	if taskId != "" {
+		// NOTE: Touch the task so that other dmts processes do not stop it
+		err = pool.touch(taskId)
+
+		if err != nil {
+			return "", err
+		}
+
+		fmt.Printf("Reuse running task: %s\n", taskId)
+	}
+
+	return taskId, nil
+}
+
+func (pool *reusePool) touch(taskId string) error {
+	return pool.ctx.Ecs.TagTask(pool.cluster, taskId, map[string]string{
+		definition.TagLastUsed: time.Now().UTC().Format(time.RFC3339),
+	})
+}
+
+// heartbeat touches the task periodically while the task is in use.
+func (pool *reusePool) heartbeat(taskId string) (stop func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(reuseHeartbeatInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				pool.touch(taskId) //nolint:errcheck
+			}
+		}
+	}()
+
+	return func() {
+		cancel()
+		<-done
+	}
+}
+
+// release touches the task and leaves it running for the next session.
+func (pool *reusePool) release(taskId string) {
+	err := pool.touch(taskId)
+
+	if err != nil {
+		fmt.Printf("Failed to update the last used time of the task: %s\n", err)
+	}
+
+	fmt.Printf("ECS task is kept for reuse (idle timeout: %s): %s\n", pool.idleTimeout, taskId)
+}
+
+// NOTE: The idle timeout tagged on the task takes precedence
+func (task *demitasTask) idle(defaultTimeout time.Duration) bool {
+	timeout, err := time.ParseDuration(task.tag(definition.TagIdleTimeout))
+
+	if err != nil || timeout <= 0 {
+		timeout = defaultTimeout
+	}
+
+	lastUsed, err := time.Parse(time.RFC3339, task.tag(definition.TagLastUsed))
+
+	if err != nil {
+		if task.StartedAt == nil {
+			return false
+		}
+
+		lastUsed = *task.StartedAt
+	}
+
+	return time.Since(lastUsed) > timeout
+}
+
+// apply tags the task as reusable and sets the default TTL if no TTL is specified.
+func (pool *reusePool) apply(def *definition.Definition, ttl time.Duration) error {
+	err := def.SetTags(pool.tags())
+
+	if err != nil {
+		return err
+	}
+
+	if ttl == 0 {
+		ttl, err = def.Overrides.TTL()
+
+		if err != nil {
+			return err
+		}
+	}
+
+	if ttl == 0 {
+		ttl = defaultReuseTTL
+	}
+
+	return applyTTL(def, ttl)
+}
+
+// NOTE: Launch a new task if the pool is nil or there is no reusable task
+func runOrReuseTask(ctx *demitas2.Context, def *definition.Definition, pool *reusePool) (string, bool, error) {
+	if pool != nil && !ctx.DryRun {
+		taskId, err := pool.acquire()
+
+		if err != nil {
+			return "", false, err
+		}
+
+		if taskId != "" {
+			return taskId, false, nil
+		}
+	}
+
+	return ctx.Runner.RunUntilRunning(def, ctx.DryRun)
+}
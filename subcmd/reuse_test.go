@@ -0,0 +1,130 @@
+package subcmd_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/kanmu/demitas2"
+	"github.com/kanmu/demitas2/definition"
+	"github.com/kanmu/demitas2/fake"
+)
+
+const (
+	reusedTaskId   = "00000000000000000000000000000001"
+	launchedTaskId = "00000000000000000000000000000002"
+)
+
+func runReusableExec(t *testing.T, ctx *demitas2.Context) {
+	t.Helper()
+	cmd := newExecCmd()
+	cmd.Reuse = true
+	cmd.IdleTimeout = 30 * time.Minute
+	var err error
+
+	captureOutput(t, func() {
+		err = cmd.Run(ctx)
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// NOTE: Update the task in memory without recording a call
+func setTaskTag(ecs *fake.ECS, taskId string, key string, value string) {
+	task := ecs.Task(taskId)
+
+	for i, tag := range task.Tags {
+		if aws.ToString(tag.Key) == key {
+			task.Tags[i].Value = aws.String(value)
+		}
+	}
+
+	ecs.AddTask(task, nil)
+}
+
+func countCalls(ecs *fake.ECS, prefix string) int {
+	n := 0
+
+	for _, c := range ecs.Calls() {
+		if strings.HasPrefix(c, prefix) {
+			n++
+		}
+	}
+
+	return n
+}
+
+func TestReuseRunningTaskWithSameHash(t *testing.T) {
+	ctx, ecs := newTestContext(t)
+	runReusableExec(t, ctx)
+	runReusableExec(t, ctx)
+
+	if n := countCalls(ecs, "RunUntilRunning "); n != 1 {
+		t.Errorf("task is launched %d times: %v", n, ecs.Calls())
+	}
+
+	if n := countCalls(ecs, "ExecuteInteractiveCommand my-cluster/"+reusedTaskId+"/"); n != 2 {
+		t.Errorf("task is not reused: %v", ecs.Calls())
+	}
+
+	if ecs.Called("StopTask") {
+		t.Errorf("reusable task is stopped: %v", ecs.Calls())
+	}
+}
+
+func TestReuseSkipsBusyTasks(t *testing.T) {
+	tests := []struct {
+		name   string
+		update func(ecs *fake.ECS)
+	}{
+		{
+			name: "task starting",
+			update: func(ecs *fake.ECS) {
+				task := ecs.Task(reusedTaskId)
+				task.LastStatus = aws.String("PENDING")
+				ecs.AddTask(task, nil)
+			},
+		},
+		{
+			name: "task of other definitions in use",
+			update: func(ecs *fake.ECS) {
+				setTaskTag(ecs, reusedTaskId, definition.TagReuse, "other-hash")
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, ecs := newTestContext(t)
+			runReusableExec(t, ctx)
+			tt.update(ecs)
+			runReusableExec(t, ctx)
+
+			if countCalls(ecs, "ExecuteInteractiveCommand my-cluster/"+launchedTaskId+"/") != 1 {
+				t.Errorf("new task is not launched: %v", ecs.Calls())
+			}
+
+			if ecs.Called("StopTask my-cluster/" + reusedTaskId) {
+				t.Errorf("busy task is stopped: %v", ecs.Calls())
+			}
+		})
+	}
+}
+
+func TestReuseStopsIdleTask(t *testing.T) {
+	ctx, ecs := newTestContext(t)
+	runReusableExec(t, ctx)
+	setTaskTag(ecs, reusedTaskId, definition.TagLastUsed, time.Now().Add(-time.Hour).UTC().Format(time.RFC3339))
+	runReusableExec(t, ctx)
+
+	if !ecs.Called("StopTask my-cluster/" + reusedTaskId) {
+		t.Errorf("idle task is not stopped: %v", ecs.Calls())
+	}
+
+	if countCalls(ecs, "ExecuteInteractiveCommand my-cluster/"+launchedTaskId+"/") != 1 {
+		t.Errorf("new task is not launched: %v", ecs.Calls())
+	}
+}
@@ -1,18 +1,24 @@
 package subcmd
 
 import (
+	"context"
 	"fmt"
+	"os"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/kanmu/demitas2"
+	"github.com/kanmu/demitas2/definition"
 )
 
 type RunCmd struct {
-	Profile string `env:"DMTS_PROFILE" short:"p" help:"Demitas profile name."`
-	Command string `help:"Command to run on a container."`
-	Image   string `help:"Container image."`
-	Cpu     uint64 `help:"Task CPU limit."`
-	Memory  uint64 `help:"Task memory limit."`
-	Detach  bool   `help:"Detach when the task starts."`
+	Profile  string `env:"DMTS_PROFILE" short:"p" predictor:"profile" help:"Demitas profile name (e.g. prod/api)."`
+	Command  string `help:"Command to run on a container."`
+	Image    string `help:"Container image."`
+	Cpu      uint64 `help:"Task CPU limit."`
+	Memory   uint64 `help:"Task memory limit."`
+	Detach   bool   `help:"Detach when the task starts."`
+	Logs     bool   `env:"DMTS_RUN_LOGS" help:"Stream CloudWatch Logs of the main container (awslogs)."`
+	LogGroup string `env:"DMTS_LOG_GROUP" help:"CloudWatch Logs group (default: awslogs-group of the container definition)."`
 }
 
 func (cmd *RunCmd) Run(ctx *demitas2.Context) error {
@@ -22,37 +28,157 @@ func (cmd *RunCmd) Run(ctx *demitas2.Context) error {
 		return err
 	}
 
+	err = applyTags(ctx, def, "run", cmd.Profile, cmd.Command)
+
+	if err != nil {
+		return err
+	}
+
+	var logConf *definition.LogConfig
+
+	if cmd.Logs || cmd.LogGroup != "" {
+		logConf, err = def.EnableLogs(cmd.LogGroup)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	rec, err := recordLaunch(ctx, def, cmd.Profile, "run")
+
+	if err != nil {
+		return err
+	}
+
 	if cmd.Detach {
-		taskId, interrupted, err := ctx.Ecspresso.RunUntilRunning(def, ctx.DryRun)
+		taskId, interrupted, err := rec.run(func() (string, bool, error) {
+			return ctx.Runner.RunUntilRunning(def, ctx.DryRun)
+		})
 
 		if err != nil {
 			return err
 		}
 
+		// NOTE: The task is detached on purpose
+		rec.done()
+
 		if interrupted {
 			return nil
 		}
 
+		if logConf != nil {
+			fmt.Printf("Logs: %s %s/*\n", logConf.Group, logConf.StreamPrefix)
+		}
+
 		fmt.Printf(`ECS task is still running.
 
 Login command:
   aws ecs execute-command --cluster %s --task %s --interactive --command bash
+  %s
 
 Task stop command:
   aws ecs stop-task --cluster %s --task %s
 `,
 			def.Cluster, taskId,
+			attachCommand(cmd.Profile, def.Cluster, "", taskId),
 			def.Cluster, taskId,
 		)
 
 		return nil
 	} else {
-		taskId, _, err := ctx.Ecspresso.RunUntilStopped(def, ctx.DryRun)
+		var tailDone chan struct{}
+		stopTail := func() {}
+
+		if logConf != nil && !ctx.DryRun {
+			tailDone, stopTail = tailLogs(ctx, logConf)
+		}
+
+		taskId, interrupted, err := rec.run(func() (string, bool, error) {
+			return ctx.Runner.RunUntilStopped(def, ctx.DryRun)
+		})
+
+		if tailDone != nil {
+			stopTail()
+			<-tailDone
+		}
 
 		defer func() {
 			ctx.Ecs.StopTask(def.Cluster, taskId) //nolint:errcheck
+			rec.done()
 		}()
 
+		if ctx.DryRun || interrupted || taskId == "" {
+			return err
+		}
+
+		exitCode, descErr := reportStoppedTask(ctx, def.Cluster, taskId, def.MainContainer)
+
+		// NOTE: Prefer the launcher error if the task could not be described
+		if descErr != nil {
+			if err != nil {
+				return err
+			}
+
+			return descErr
+		}
+
+		if exitCode != 0 {
+			return &demitas2.ExitError{Code: exitCode}
+		}
+
 		return err
 	}
 }
+
+// NOTE: Logs are tailed until stopTail is called and a while after that
+func tailLogs(ctx *demitas2.Context, logConf *definition.LogConfig) (chan struct{}, func()) {
+	tailCtx, stopTail := context.WithCancel(context.Background())
+	tailDone := make(chan struct{})
+	fmt.Fprintf(os.Stderr, "Streaming logs: %s %s/*\n", logConf.Group, logConf.StreamPrefix)
+
+	go func() {
+		defer close(tailDone)
+		err := ctx.Ecs.TailLogs(tailCtx, logConf.Region, logConf.Group, logConf.StreamPrefix, os.Stdout)
+
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to stream logs: %s\n", err)
+		}
+	}()
+
+	return tailDone, stopTail
+}
+
+// reportStoppedTask prints the stop reason of the task and returns the exit code of the container.
+func reportStoppedTask(ctx *demitas2.Context, cluster string, taskId string, container string) (int, error) {
+	task, err := ctx.Ecs.DescribeTask(cluster, taskId)
+
+	if err != nil {
+		return 0, err
+	}
+
+	if reason := aws.ToString(task.StoppedReason); reason != "" {
+		fmt.Fprintf(os.Stderr, "Task stopped: %s\n", reason)
+	}
+
+	for _, c := range task.Containers {
+		if container != "" && aws.ToString(c.Name) != container {
+			continue
+		}
+
+		if c.ExitCode == nil {
+			return 0, fmt.Errorf("container '%s' has no exit code: %s: %s/%s", aws.ToString(c.Name), aws.ToString(c.Reason), taskId, cluster)
+		}
+
+		exitCode := int(aws.ToInt32(c.ExitCode))
+
+		if reason := aws.ToString(c.Reason); reason != "" {
+			fmt.Fprintf(os.Stderr, "Container '%s' exited with code %d: %s\n", aws.ToString(c.Name), exitCode, reason)
+		} else {
+			fmt.Fprintf(os.Stderr, "Container '%s' exited with code %d\n", aws.ToString(c.Name), exitCode)
+		}
+
+		return exitCode, nil
+	}
+
+	return 0, fmt.Errorf("container '%s' not found in task: %s/%s", container, taskId, cluster)
+}
@@ -0,0 +1,89 @@
+package subcmd_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
+	"github.com/kanmu/demitas2"
+	"github.com/kanmu/demitas2/subcmd"
+)
+
+const runTaskId = "00000000000000000000000000000001"
+
+func TestRunExitsWithExitCodeOfContainer(t *testing.T) {
+	ctx, ecs := newTestContext(t)
+	ecs.ExitCode = 3
+
+	var err error
+
+	_, stderr := captureOutput(t, func() {
+		err = (&subcmd.RunCmd{Profile: "prod", Command: "rake batch"}).Run(ctx)
+	})
+
+	var exitErr *demitas2.ExitError
+
+	if !errors.As(err, &exitErr) || exitErr.Code != 3 {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !strings.Contains(stderr, "Container 'app' exited with code 3") {
+		t.Errorf("exit code is not reported: %q", stderr)
+	}
+
+	if !ecs.Called("StopTask my-cluster/" + runTaskId) {
+		t.Errorf("task is not stopped: %v", ecs.Calls())
+	}
+}
+
+func TestRunFailsWithoutExitCode(t *testing.T) {
+	tests := []struct {
+		name       string
+		containers []types.Container
+		err        string
+	}{
+		{
+			name:       "no exit code",
+			containers: []types.Container{{Name: aws.String("app"), Reason: aws.String("CannotPullContainerError")}},
+			err:        "container 'app' has no exit code: CannotPullContainerError",
+		},
+		{
+			name:       "container not found",
+			containers: []types.Container{{Name: aws.String("sidecar"), ExitCode: aws.Int32(0)}},
+			err:        "container 'app' not found in task",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, ecs := newTestContext(t)
+
+			// NOTE: Replace the containers of the stopped task before it is described
+			ecs.Hook = func(call string) {
+				if call == "DescribeTask my-cluster/"+runTaskId {
+					task := ecs.Task(runTaskId)
+					task.Containers = tt.containers
+					ecs.AddTask(task, nil)
+				}
+			}
+
+			var err error
+
+			captureOutput(t, func() {
+				err = (&subcmd.RunCmd{Profile: "prod", Command: "rake batch"}).Run(ctx)
+			})
+
+			var exitErr *demitas2.ExitError
+
+			if err == nil || errors.As(err, &exitErr) || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if !ecs.Called("StopTask my-cluster/" + runTaskId) {
+				t.Errorf("task is not stopped: %v", ecs.Calls())
+			}
+		})
+	}
+}
@@ -0,0 +1,234 @@
+package subcmd
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/kanmu/demitas2"
+	"github.com/kanmu/demitas2/definition"
+)
+
+const stateFileName = ".dmts-state.json"
+
+// launchedTask is an entry of the state file.
+// NOTE: The task ID is empty until the launcher returns it, so the task is also tagged with the run ID
+type launchedTask struct {
+	RunId     string    `json:"run_id"`
+	TaskId    string    `json:"task_id,omitempty"`
+	Cluster   string    `json:"cluster"`
+	Profile   string    `json:"profile"`
+	Mode      string    `json:"mode"`
+	Pid       int       `json:"pid"`
+	Hostname  string    `json:"hostname"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// NOTE: Entries of processes that are still running are not orphaned
+func (task *launchedTask) orphaned() bool {
+	if hostname, _ := os.Hostname(); task.Hostname != hostname {
+		return false
+	}
+
+	err := syscall.Kill(task.Pid, 0)
+
+	return err != nil && !errors.Is(err, syscall.EPERM)
+}
+
+// stateFile records tasks launched by dmts so that they can be stopped
+// even if dmts is killed before the teardown.
+type stateFile struct {
+	path string
+}
+
+func newStateFile(ctx *demitas2.Context) *stateFile {
+	return &stateFile{
+		path: filepath.Join(ctx.DefinitionOpts.ExpandConfDir(), stateFileName),
+	}
+}
+
+func (state *stateFile) list() ([]*launchedTask, error) {
+	tasks := []*launchedTask{}
+	content, err := os.ReadFile(state.path)
+
+	if errors.Is(err, os.ErrNotExist) {
+		return tasks, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read state file: %w", err)
+	}
+
+	err = json.Unmarshal(content, &tasks)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse state file: %w: %s", err, state.path)
+	}
+
+	return tasks, nil
+}
+
+// NOTE: Lock the state file because multiple dmts processes may update it at the same time
+func (state *stateFile) update(fn func([]*launchedTask) []*launchedTask) error {
+	lock, err := os.OpenFile(state.path+".lock", os.O_CREATE|os.O_RDWR, 0o600)
+
+	if err != nil {
+		return fmt.Errorf("failed to open state lock file: %w", err)
+	}
+
+	defer lock.Close()
+
+	err = syscall.Flock(int(lock.Fd()), syscall.LOCK_EX)
+
+	if err != nil {
+		return fmt.Errorf("failed to lock state file: %w", err)
+	}
+
+	defer syscall.Flock(int(lock.Fd()), syscall.LOCK_UN) //nolint:errcheck
+
+	tasks, err := state.list()
+
+	if err != nil {
+		return err
+	}
+
+	content, err := json.MarshalIndent(fn(tasks), "", "  ")
+
+	if err != nil {
+		panic(err)
+	}
+
+	tmpPath := state.path + ".tmp"
+	err = os.WriteFile(tmpPath, content, 0o600)
+
+	if err != nil {
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+
+	err = os.Rename(tmpPath, state.path)
+
+	if err != nil {
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+
+	return nil
+}
+
+func (state *stateFile) remove(runIds ...string) error {
+	return state.update(func(tasks []*launchedTask) []*launchedTask {
+		newTasks := []*launchedTask{}
+
+		for _, task := range tasks {
+			removed := false
+
+			for _, runId := range runIds {
+				if task.RunId == runId {
+					removed = true
+				}
+			}
+
+			if !removed {
+				newTasks = append(newTasks, task)
+			}
+		}
+
+		return newTasks
+	})
+}
+
+// launchRecord is the entry of the task being launched by this process.
+type launchRecord struct {
+	state *stateFile
+	runId string
+}
+
+// recordLaunch tags the task with a run ID and records it in the state file before launching the task.
+// NOTE: Return nil on dry run
+func recordLaunch(ctx *demitas2.Context, def *definition.Definition, profile string, mode string) (*launchRecord, error) {
+	if ctx.DryRun {
+		return nil, nil
+	}
+
+	nonce := make([]byte, 8)
+
+	if _, err := rand.Read(nonce); err != nil {
+		panic(err)
+	}
+
+	runId := hex.EncodeToString(nonce)
+	err := def.SetTags(map[string]string{definition.TagRunId: runId})
+
+	if err != nil {
+		return nil, err
+	}
+
+	hostname, _ := os.Hostname()
+	task := &launchedTask{
+		RunId:     runId,
+		Cluster:   def.Cluster,
+		Profile:   profile,
+		Mode:      mode,
+		Pid:       os.Getpid(),
+		Hostname:  hostname,
+		CreatedAt: time.Now(),
+	}
+
+	state := newStateFile(ctx)
+	err = state.update(func(tasks []*launchedTask) []*launchedTask {
+		return append(tasks, task)
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &launchRecord{state: state, runId: runId}, nil
+}
+
+// run calls the launcher and records the task ID.
+// NOTE: The entry is kept only if the task may be left running
+func (rec *launchRecord) run(launch func() (string, bool, error)) (string, bool, error) {
+	taskId, interrupted, err := launch()
+
+	if rec == nil {
+		return taskId, interrupted, err
+	}
+
+	if taskId == "" || interrupted {
+		rec.done()
+		return taskId, interrupted, err
+	}
+
+	updateErr := rec.state.update(func(tasks []*launchedTask) []*launchedTask {
+		for _, task := range tasks {
+			if task.RunId == rec.runId {
+				task.TaskId = taskId
+			}
+		}
+
+		return tasks
+	})
+
+	if updateErr != nil {
+		fmt.Fprintf(os.Stderr, "Failed to update state file: %s\n", updateErr)
+	}
+
+	return taskId, interrupted, err
+}
+
+// done removes the entry from the state file on teardown.
+func (rec *launchRecord) done() {
+	if rec == nil {
+		return
+	}
+
+	err := rec.state.remove(rec.runId)
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to update state file: %s\n", err)
+	}
+}
@@ -0,0 +1,92 @@
+package subcmd
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/kanmu/demitas2"
+)
+
+type StopCmd struct {
+	Profile   string        `env:"DMTS_PROFILE" short:"p" predictor:"profile" help:"Demitas profile name (e.g. prod/api)."`
+	Mine      bool          `help:"Stop all my tasks."`
+	OlderThan time.Duration `help:"Stop my tasks started before the duration (e.g. 12h)."`
+	All       bool          `help:"Stop tasks of all users with --older-than."`
+	Force     bool          `help:"Stop the tasks even if they are not launched by demitas."`
+	Yes       bool          `short:"y" help:"Stop tasks without confirmation."`
+	TaskIds   []string      `arg:"" optional:"" name:"task-id" help:"ECS task IDs."`
+}
+
+func (cmd *StopCmd) Run(ctx *demitas2.Context) error {
+	if len(cmd.TaskIds) == 0 && !cmd.Mine && cmd.OlderThan == 0 {
+		return fmt.Errorf("specify task IDs, --mine or --older-than")
+	}
+
+	if cmd.All && (cmd.Mine || cmd.OlderThan == 0) {
+		return fmt.Errorf("--all can only be used with --older-than")
+	}
+
+	def, err := ctx.DefinitionOpts.Load(cmd.Profile, "", "", 0, 0, false)
+
+	if err != nil {
+		return err
+	}
+
+	taskIds := cmd.TaskIds
+
+	if len(taskIds) == 0 {
+		tasks, err := listDemitasTasks(ctx, def.Cluster, cmd.All)
+
+		if err != nil {
+			return err
+		}
+
+		for _, task := range tasks {
+			if cmd.OlderThan != 0 && (task.StartedAt == nil || time.Since(*task.StartedAt) < cmd.OlderThan) {
+				continue
+			}
+
+			fmt.Printf("%s  %s  %s\n", task.id(), task.owner(), task.startedAt())
+			taskIds = append(taskIds, task.id())
+		}
+
+		if len(taskIds) == 0 {
+			fmt.Println("No tasks to stop.")
+			return nil
+		}
+
+		if !cmd.Yes && !confirm(fmt.Sprintf("Stop %d task(s)?", len(taskIds))) {
+			return nil
+		}
+	} else if !cmd.Force {
+		// NOTE: Check all tasks before stopping any of them
+		for _, taskId := range taskIds {
+			task, err := ctx.Ecs.DescribeTask(def.Cluster, taskId)
+
+			if err != nil {
+				return err
+			}
+
+			ok, err := launchedByDemitas(ctx, &demitasTask{Task: *task})
+
+			if err != nil {
+				return err
+			}
+
+			if !ok {
+				return fmt.Errorf("task is not launched by demitas (use --force to stop it): %s", taskId)
+			}
+		}
+	}
+
+	for _, taskId := range taskIds {
+		fmt.Printf("Stopping task: %s\n", taskId)
+		err = ctx.Ecs.StopTask(def.Cluster, taskId)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,57 @@
+package subcmd_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/kanmu/demitas2/definition"
+	"github.com/kanmu/demitas2/subcmd"
+)
+
+func TestStopRefusesOtherTasks(t *testing.T) {
+	ctx, ecs := newTestContext(t)
+	addRunningTask(ecs, "webtask", "web")
+
+	cmd := &subcmd.StopCmd{Profile: "prod", TaskIds: []string{"webtask"}}
+	err := cmd.Run(ctx)
+
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+
+	if ecs.Called("StopTask") {
+		t.Errorf("task not launched by demitas is stopped: %v", ecs.Calls())
+	}
+
+	cmd.Force = true
+	err = cmd.Run(ctx)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !ecs.Called("StopTask my-cluster/webtask") {
+		t.Errorf("task is not stopped with --force: %v", ecs.Calls())
+	}
+}
+
+func TestStopOlderThanStopsMyTasks(t *testing.T) {
+	ctx, ecs := newTestContext(t)
+	addRunningTask(ecs, "mytask", definition.FamilyPrefix()+"my-app")
+	addRunningTask(ecs, "othertask", "dmts-other-my-app")
+
+	cmd := &subcmd.StopCmd{Profile: "prod", OlderThan: time.Minute, Yes: true}
+	err := cmd.Run(ctx)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !ecs.Called("StopTask my-cluster/mytask") {
+		t.Errorf("my task is not stopped: %v", ecs.Calls())
+	}
+
+	if ecs.Called("StopTask my-cluster/othertask") {
+		t.Errorf("task of other user is stopped without --all: %v", ecs.Calls())
+	}
+}
@@ -0,0 +1,76 @@
+package subcmd_test
+
+import (
+	"io"
+	"os"
+	"testing"
+
+	"github.com/kanmu/demitas2"
+	"github.com/kanmu/demitas2/definition"
+	"github.com/kanmu/demitas2/fake"
+)
+
+// NOTE: Copy the conf dir because commands write the state file in it
+func newTestContext(t *testing.T) (*demitas2.Context, *fake.ECS) {
+	t.Helper()
+	confDir := t.TempDir()
+	err := os.CopyFS(confDir, os.DirFS("testdata/conf"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ecs := fake.NewECS()
+	ctx := &demitas2.Context{
+		Runner: ecs,
+		Ecs:    ecs,
+		DefinitionOpts: &definition.DefinitionOpts{
+			ConfDir:       confDir,
+			Config:        []string{"ecspresso.yml"},
+			ContainerDef:  "ecs-container-def.jsonnet",
+			OverridesFile: ".demitas.jsonnet",
+		},
+		Exit: func(code int) {
+			t.Errorf("unexpected exit: %d", code)
+		},
+	}
+
+	return ctx, ecs
+}
+
+// NOTE: Return what f prints to stdout and stderr
+func captureOutput(t *testing.T, f func()) (string, string) {
+	t.Helper()
+	stdout, stderr := os.Stdout, os.Stderr
+
+	defer func() {
+		os.Stdout, os.Stderr = stdout, stderr
+	}()
+
+	outc := redirect(t, &os.Stdout)
+	errc := redirect(t, &os.Stderr)
+	f()
+	os.Stdout.Close()
+	os.Stderr.Close()
+
+	return <-outc, <-errc
+}
+
+func redirect(t *testing.T, file **os.File) <-chan string {
+	t.Helper()
+	r, w, err := os.Pipe()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	*file = w
+	out := make(chan string)
+
+	go func() {
+		bs, _ := io.ReadAll(r)
+		out <- string(bs)
+	}()
+
+	return out
+}
@@ -0,0 +1,340 @@
+package subcmd
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
+	"github.com/kanmu/demitas2"
+	"github.com/kanmu/demitas2/definition"
+)
+
+type demitasTask struct {
+	types.Task
+	taskDef *types.TaskDefinition
+}
+
+func (task *demitasTask) id() string {
+	taskArn := aws.ToString(task.TaskArn)
+	return taskArn[strings.LastIndex(taskArn, "/")+1:]
+}
+
+func (task *demitasTask) family() string {
+	taskDefArn := aws.ToString(task.TaskDefinitionArn)
+	family := taskDefArn[strings.LastIndex(taskDefArn, "/")+1:]
+
+	if i := strings.LastIndex(family, ":"); i >= 0 {
+		family = family[:i]
+	}
+
+	return family
+}
+
+func (task *demitasTask) tag(key string) string {
+	for _, t := range task.Tags {
+		if aws.ToString(t.Key) == key {
+			return aws.ToString(t.Value)
+		}
+	}
+
+	return ""
+}
+
+func (task *demitasTask) hasTags(tags map[string]string) bool {
+	for k, v := range tags {
+		if task.tag(k) != v {
+			return false
+		}
+	}
+
+	return true
+}
+
+// NOTE: The family is "dmts-<owner>-<original family>" if the task is not tagged
+func (task *demitasTask) owner() string {
+	if user := task.tag(definition.TagUser); user != "" {
+		return user
+	}
+
+	family := strings.TrimPrefix(task.family(), "dmts-")
+
+	if i := strings.Index(family, "-"); i > 0 {
+		return family[:i]
+	}
+
+	return "-"
+}
+
+func (task *demitasTask) isMine() bool {
+	return strings.HasPrefix(task.family(), definition.FamilyPrefix())
+}
+
+// NOTE: Allow a clock skew between the local host and ECS
+func (task *demitasTask) launchedAfter(t time.Time) bool {
+	launchedAt := task.CreatedAt
+
+	if launchedAt == nil {
+		launchedAt = task.StartedAt
+	}
+
+	return launchedAt == nil || launchedAt.After(t.Add(-1*time.Minute))
+}
+
+func (task *demitasTask) startedAt() string {
+	if task.StartedAt == nil {
+		return "-"
+	}
+
+	return task.StartedAt.Local().Format("2006-01-02 15:04:05")
+}
+
+// NOTE: The main container is the first container in containerDefinitions
+func (task *demitasTask) image() string {
+	if task.taskDef == nil || len(task.taskDef.ContainerDefinitions) == 0 {
+		return "-"
+	}
+
+	return aws.ToString(task.taskDef.ContainerDefinitions[0].Image)
+}
+
+func (task *demitasTask) command() string {
+	if task.taskDef == nil || len(task.taskDef.ContainerDefinitions) == 0 {
+		return "-"
+	}
+
+	containerDef := task.taskDef.ContainerDefinitions[0]
+	command := containerDef.Command
+
+	if task.Overrides != nil {
+		for _, o := range task.Overrides.ContainerOverrides {
+			if aws.ToString(o.Name) == aws.ToString(containerDef.Name) && len(o.Command) > 0 {
+				command = o.Command
+			}
+		}
+	}
+
+	if len(command) == 0 {
+		return "-"
+	}
+
+	return strings.Join(command, " ")
+}
+
+// NOTE: Remaining time until the TTL set by definition.SetTTL
+func (task *demitasTask) remaining() string {
+	if task.taskDef == nil || len(task.taskDef.ContainerDefinitions) == 0 || task.StartedAt == nil {
+		return "-"
+	}
+
+	for _, e := range task.taskDef.ContainerDefinitions[0].Environment {
+		if aws.ToString(e.Name) != definition.TTLEnvName {
+			continue
+		}
+
+		secs, err := strconv.Atoi(aws.ToString(e.Value))
+
+		if err != nil {
+			return "-"
+		}
+
+		remaining := time.Until(task.StartedAt.Add(time.Duration(secs) * time.Second))
+
+		if remaining < 0 {
+			return "expired"
+		}
+
+		return remaining.Truncate(time.Minute).String()
+	}
+
+	return "-"
+}
+
+func applyTags(ctx *demitas2.Context, def *definition.Definition, subcommand string, profile string, command string) error {
+	tags := def.Overrides.Tags()
+	tags[definition.TagUser] = definition.Username()
+	tags[definition.TagProfile] = profile
+	tags[definition.TagSubcommand] = subcommand
+	tags[definition.TagCommand] = command
+
+	if ctx.Version != "" {
+		tags[definition.TagVersion] = ctx.Version
+	}
+
+	if hostname, err := os.Hostname(); err == nil {
+		tags[definition.TagHostname] = hostname
+	}
+
+	return def.SetTags(tags)
+}
+
+func applyTTL(def *definition.Definition, ttl time.Duration) error {
+	if ttl == 0 {
+		var err error
+		ttl, err = def.Overrides.TTL()
+
+		if err != nil {
+			return err
+		}
+	}
+
+	if ttl == 0 {
+		return nil
+	}
+
+	return def.SetTTL(ttl)
+}
+
+func hasDemitasEnv(taskDef *types.TaskDefinition) bool {
+	if taskDef == nil {
+		return false
+	}
+
+	for _, c := range taskDef.ContainerDefinitions {
+		for _, e := range c.Environment {
+			if aws.ToString(e.Name) == "DEMITAS" && aws.ToString(e.Value) == "true" {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// NOTE: A task is launched by demitas if the family has the prefix "dmts-", it is tagged with the user or has DEMITAS=true environment
+func launchedByDemitas(ctx *demitas2.Context, task *demitasTask) (bool, error) {
+	if strings.HasPrefix(task.family(), "dmts-") || task.tag(definition.TagUser) != "" {
+		return true, nil
+	}
+
+	taskDef, err := ctx.Ecs.DescribeTaskDefinition(aws.ToString(task.TaskDefinitionArn))
+
+	if err != nil {
+		return false, err
+	}
+
+	return hasDemitasEnv(taskDef), nil
+}
+
+// NOTE: List my tasks, or all tasks launched by demitas (family prefix "dmts-" or DEMITAS=true environment)
+func listDemitasTasks(ctx *demitas2.Context, cluster string, all bool) ([]*demitasTask, error) {
+	tasks, err := ctx.Ecs.ListTasks(cluster)
+
+	if err != nil {
+		return nil, err
+	}
+
+	taskDefs := map[string]*types.TaskDefinition{}
+	dmtsTasks := []*demitasTask{}
+
+	for _, t := range tasks {
+		task := &demitasTask{Task: t}
+
+		if task.isMine() {
+			dmtsTasks = append(dmtsTasks, task)
+			continue
+		}
+
+		if !all {
+			continue
+		}
+
+		if strings.HasPrefix(task.family(), "dmts-") {
+			dmtsTasks = append(dmtsTasks, task)
+			continue
+		}
+
+		taskDefArn := aws.ToString(task.TaskDefinitionArn)
+		taskDef, ok := taskDefs[taskDefArn]
+
+		if !ok {
+			taskDef, err = ctx.Ecs.DescribeTaskDefinition(taskDefArn)
+
+			if err != nil {
+				return nil, err
+			}
+
+			taskDefs[taskDefArn] = taskDef
+		}
+
+		if hasDemitasEnv(taskDef) {
+			task.taskDef = taskDef
+			dmtsTasks = append(dmtsTasks, task)
+		}
+	}
+
+	sort.SliceStable(dmtsTasks, func(i, j int) bool {
+		return aws.ToTime(dmtsTasks[i].StartedAt).Before(aws.ToTime(dmtsTasks[j].StartedAt))
+	})
+
+	return dmtsTasks, nil
+}
+
+func describeTaskDefinitions(ctx *demitas2.Context, tasks []*demitasTask) error {
+	taskDefs := map[string]*types.TaskDefinition{}
+
+	for _, task := range tasks {
+		if task.taskDef != nil {
+			continue
+		}
+
+		taskDefArn := aws.ToString(task.TaskDefinitionArn)
+		taskDef, ok := taskDefs[taskDefArn]
+
+		if !ok {
+			var err error
+			taskDef, err = ctx.Ecs.DescribeTaskDefinition(taskDefArn)
+
+			if err != nil {
+				return err
+			}
+
+			taskDefs[taskDefArn] = taskDef
+		}
+
+		task.taskDef = taskDef
+	}
+
+	return nil
+}
+
+func confirm(msg string) bool {
+	fmt.Printf("%s [y/N]: ", msg)
+	line, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	answer := strings.ToLower(strings.TrimSpace(line))
+	return answer == "y" || answer == "yes"
+}
+
+func selectTask(tasks []*demitasTask) (*demitasTask, error) {
+	if len(tasks) == 0 {
+		return nil, fmt.Errorf("running task not found")
+	}
+
+	if len(tasks) == 1 {
+		return tasks[0], nil
+	}
+
+	for i, task := range tasks {
+		fmt.Printf("[%d] %s  %s  %s\n", i+1, task.id(), task.family(), task.startedAt())
+	}
+
+	fmt.Print("Select task: ")
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to read task number: %w", err)
+	}
+
+	n, err := strconv.Atoi(strings.TrimSpace(line))
+
+	if err != nil || n < 1 || n > len(tasks) {
+		return nil, fmt.Errorf("invalid task number: %s", strings.TrimSpace(line))
+	}
+
+	return tasks[n-1], nil
+}
@@ -0,0 +1,3 @@
+{
+  image: 'example/app:v1',
+}
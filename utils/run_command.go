@@ -1,6 +1,7 @@
 package utils
 
 import (
+	"context"
 	"io"
 	"os"
 	"os/exec"
@@ -13,7 +14,18 @@ import (
 )
 
 func RunCommand(cmdWithArgs []string, silent bool) (string, string, bool, error) {
-	cmd := exec.Command(cmdWithArgs[0], cmdWithArgs[1:]...)
+	return RunCommandContext(context.Background(), cmdWithArgs, silent)
+}
+
+// RunCommandContext runs the command and sends SIGINT to it when the context is canceled.
+func RunCommandContext(ctx context.Context, cmdWithArgs []string, silent bool) (string, string, bool, error) {
+	return RunCommandContextTee(ctx, cmdWithArgs, silent, nil)
+}
+
+// RunCommandContextTee is RunCommandContext that also writes stdout to tee while the command is running.
+func RunCommandContextTee(ctx context.Context, cmdWithArgs []string, silent bool, tee io.Writer) (string, string, bool, error) {
+	cmd := exec.CommandContext(ctx, cmdWithArgs[0], cmdWithArgs[1:]...)
+	cmd.Cancel = func() error { return cmd.Process.Signal(os.Interrupt) }
 	interrupted := atomic.NewBool(false)
 
 	outReader, err := cmd.StdoutPipe()
@@ -54,6 +66,10 @@ func RunCommand(cmdWithArgs []string, silent bool) (string, string, bool, error)
 			out = append(out, os.Stdout)
 		}
 
+		if tee != nil {
+			out = append(out, tee)
+		}
+
 		w := io.MultiWriter(out...)
 		_, _ = io.Copy(w, outReader)
 		wg.Done()
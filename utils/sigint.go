@@ -2,34 +2,35 @@ package utils
 
 import (
 	"os"
-	"os/signal"
-	"syscall"
 
 	"go.uber.org/atomic"
 )
 
-func TrapInt(proc func() error, teardown0 func()) error {
-	sig := make(chan os.Signal, 1)
-	signal.Notify(sig, os.Interrupt)
-	signal.Ignore(syscall.SIGURG)
-	signal.Ignore(syscall.SIGWINCH)
+// TrapInt runs proc and then teardown0.
+// If a signal is received from sig while proc is running, teardown0 is run and exit is called with 130.
+func TrapInt(sig <-chan os.Signal, exit func(int), proc func() error, teardown0 func()) error {
 	stopped := atomic.NewBool(false)
 
 	teardown := func() {
-		if stopped.Load() {
+		if !stopped.CompareAndSwap(false, true) {
 			return
 		}
 
-		stopped.Store(true)
 		teardown0()
 	}
 
 	defer teardown()
 
+	done := make(chan struct{})
+	defer close(done)
+
 	go func() {
-		<-sig
-		teardown()
-		os.Exit(130)
+		select {
+		case <-sig:
+			teardown()
+			exit(130)
+		case <-done:
+		}
 	}()
 
 	return proc()
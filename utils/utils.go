@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/goccy/go-yaml"
 	"github.com/google/go-jsonnet"
@@ -93,3 +94,8 @@ func ReadJSONorJsonnet(path string) ([]byte, error) {
 
 	return content, nil
 }
+
+// ShellQuote quotes the string for POSIX shells.
+func ShellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}